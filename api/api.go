@@ -2,26 +2,62 @@ package api
 
 import (
 	dbtablesgo "avito_otbor/dbTablesGo"
+	"avito_otbor/errs"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-func Init(r chi.Router) {
-	r.Get("/team/get", teamGetHandle)
-	r.Post("/team/add", AddTeamHandle)
-	r.Post("/users/setIsActive", SetIsActiveHandle)
-	r.Post("/pullRequest/create", PrCreateHandle)
-	r.Post("/pullRequest/merge", ChangeStatusHandle)
-	r.Post("/pullRequest/reassign", ChangeReviewerHandle)
-	r.Get("/users/getReview", GetReviewHandle)
-	r.Post("/users/deactivateMany", DeactivateManyHandle)
+type Handler struct {
+	store dbtablesgo.Store
+	// adminToken is the X-Admin-Token that allows merge overrides; when it
+	// is empty overrides are disabled.
+	adminToken string
 }
 
-func DeactivateManyHandle(w http.ResponseWriter, r *http.Request) {
+func NewHandler(store dbtablesgo.Store) *Handler {
+	return &Handler{store: store, adminToken: os.Getenv("ADMIN_TOKEN")}
+}
+
+func Init(r chi.Router, store dbtablesgo.Store) {
+	h := NewHandler(store)
+	r.Get("/team/get", h.teamGetHandle)
+	r.Post("/team/add", h.AddTeamHandle)
+	r.Get("/team/list", h.ListTeamsHandle)
+	r.Post("/team/addMembers", h.AddMembersHandle)
+	r.Post("/team/removeMembers", h.RemoveMembersHandle)
+	r.Post("/team/rename", h.RenameTeamHandle)
+	r.Post("/team/delete", h.DeleteTeamHandle)
+	r.Get("/users/get", h.GetUserHandle)
+	r.Post("/users/setIsActive", h.SetIsActiveHandle)
+	r.Post("/users/setCapacity", h.SetCapacityHandle)
+	r.Post("/pullRequest/create", h.PrCreateHandle)
+	r.Post("/pullRequest/preview", h.PrPreviewHandle)
+	r.Post("/pullRequest/merge", h.ChangeStatusHandle)
+	r.Get("/pullRequest/mergeOverrides", h.MergeOverridesHandle)
+	r.Post("/pullRequest/ready", h.transitionHandle(dbtablesgo.ActionReady))
+	r.Post("/pullRequest/close", h.transitionHandle(dbtablesgo.ActionClose))
+	r.Post("/pullRequest/reopen", h.transitionHandle(dbtablesgo.ActionReopen))
+	r.Post("/pullRequest/approve", h.reviewHandle(dbtablesgo.VerdictApproved))
+	r.Post("/pullRequest/requestChanges", h.reviewHandle(dbtablesgo.VerdictChangesRequested))
+	r.Post("/pullRequest/reassign", h.ChangeReviewerHandle)
+	r.Post("/pullRequest/decline", h.DeclineHandle)
+	r.Get("/users/getReview", h.GetReviewHandle)
+	r.Post("/users/deactivateMany", h.DeactivateManyHandle)
+	r.Post("/users/handOver", h.HandOverHandle)
+	r.Get("/stats", h.StatsHandle)
+}
+
+func (h *Handler) DeactivateManyHandle(w http.ResponseWriter, r *http.Request) {
 	type DeactivateManyRequest struct {
-		UserIDs []string `json:"user_ids"`
+		UserIDs []string                    `json:"user_ids"`
+		Mode    dbtablesgo.DeactivationMode `json:"mode"`
 	}
 	var req DeactivateManyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -34,28 +70,79 @@ func DeactivateManyHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := dbtablesgo.DeactivateManyUsers(req.UserIDs)
+	switch req.Mode {
+	case "":
+		req.Mode = dbtablesgo.DeactivateStrict
+	case dbtablesgo.DeactivateStrict, dbtablesgo.DeactivateLenient:
+	default:
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "mode must be strict or lenient")
+		return
+	}
+
+	report, err := h.store.DeactivateManyUsers(req.UserIDs, req.Mode)
 	if err != nil {
-		if err.Error() == "NO_REVIEWERS" {
-			ErrorJSON(w, http.StatusBadRequest, "NO_REVIEWERS", "no reviewers available")
-			return
-		}
-		ErrorJSON(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to process request")
+		WriteError(w, err, "failed to process request")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(
-		map[string]string{"status": "ok"}); err != nil {
+	if err := json.NewEncoder(w).Encode(report); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func StatsHandle(w http.ResponseWriter, _ *http.Request) {
-	stats, err := dbtablesgo.GetStats()
+func (h *Handler) HandOverHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		UserID      string `json:"user_id"`
+		SuccessorID string `json:"successor_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "invalid json")
+		return
+	}
+	if body.UserID == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "user_id cannot be empty")
+		return
+	}
+	if body.SuccessorID == body.UserID {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "successor_id must differ from user_id")
+		return
+	}
+	report, err := h.store.HandOverReviews(body.UserID, body.SuccessorID)
 	if err != nil {
-		ErrorJSON(w, http.StatusInternalServerError, "INTERNAL_ERROR", "failed to get stats")
+		WriteError(w, err, "failed to hand over reviews")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) StatsHandle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := dbtablesgo.StatsFilter{Team: q.Get("team_name")}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", p.name+" must be an RFC3339 timestamp")
+			return
+		}
+		*p.dst = &t
+	}
+	stats, err := h.store.GetStats(filter)
+	if err != nil {
+		WriteError(w, err, "failed to get stats")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -66,22 +153,16 @@ func StatsHandle(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
-func GetReviewHandle(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetReviewHandle(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "id cant be empty")
 		return
 	}
-	pqList, err := dbtablesgo.GetReview(userID)
+	pqList, err := h.store.GetReview(userID)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			ErrorJSON(w, http.StatusBadRequest, "NOT_FOUND", "user has no assigned PRs")
-			return
-
-		}
-		ErrorJSON(w, http.StatusBadRequest, "INTERNAL_ERROR", "problems with getting reviews")
+		WriteError(w, err, "problems with getting reviews")
 		return
-
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -94,10 +175,11 @@ func GetReviewHandle(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
-func ChangeReviewerHandle(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ChangeReviewerHandle(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		PullRequestID string `json:"pull_request_id"`
 		OldUserID     string `json:"old_user_id"`
+		NewUserID     string `json:"new_user_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
@@ -107,36 +189,56 @@ func ChangeReviewerHandle(w http.ResponseWriter, r *http.Request) {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Id must be not empty")
 		return
 	}
-	updated, replacedBy, err := dbtablesgo.ChangeReviewer(body.PullRequestID, body.OldUserID)
+	res, err := h.store.ChangeReviewer(body.PullRequestID, body.OldUserID, body.NewUserID)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			ErrorJSON(w, http.StatusBadRequest, "NOT_FOUND", "cant find pr")
-			return
-		} else if err.Error() == "NOT_ASSIGNED" {
-			ErrorJSON(w, http.StatusBadRequest, "NOT_ASSIGNED", "resource not assigned")
-			return
-		} else if err.Error() == "NO_REPLACEMENT_FOUND" {
-			ErrorJSON(w, http.StatusBadRequest, "NO_REPLACEMENT_FOUND", "there is no person to replace")
-			return
-		}
-		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Problems with changing reviewer")
+		WriteError(w, err, "Problems with changing reviewer")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
+	}
+}
 
+func (h *Handler) DeclineHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PullRequestID string `json:"pull_request_id"`
+		UserID        string `json:"user_id"`
+		Reason        string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
+		return
+	}
+	if body.PullRequestID == "" || body.UserID == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "pull_request_id and user_id must be not empty")
+		return
+	}
+	if body.Reason == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "reason must be not empty")
+		return
+	}
+	res, err := h.store.DeclineReview(body.PullRequestID, body.UserID, body.Reason)
+	if err != nil {
+		WriteError(w, err, "Problems with declining review")
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"updated":     updated,
-		"replaced_by": replacedBy,
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(res); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
-func ChangeStatusHandle(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ChangeStatusHandle(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		PullRequestID string `json:"pull_request_id"`
+		Override      bool   `json:"override"`
+		Actor         string `json:"actor"`
+		Reason        string `json:"reason"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
@@ -146,14 +248,26 @@ func ChangeStatusHandle(w http.ResponseWriter, r *http.Request) {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Id must be not empty")
 		return
 	}
-	updated, err := dbtablesgo.StatusMerged(body.PullRequestID)
-	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			ErrorJSON(w, http.StatusNotFound, "NOT_FOUND", "PR not found")
+	var override *dbtablesgo.MergeOverride
+	if body.Override {
+		if h.adminToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(h.adminToken)) != 1 {
+			ErrorJSON(w, http.StatusForbidden, "FORBIDDEN", "merge override needs a valid X-Admin-Token")
+			return
+		}
+		if body.Actor == "" || body.Reason == "" {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "override needs actor and reason")
 			return
 		}
-		if err.Error() == "ALREADY_MERGED" {
-			pr, _ := dbtablesgo.GetPR(body.PullRequestID)
+		override = &dbtablesgo.MergeOverride{Actor: body.Actor, Reason: body.Reason}
+	}
+	updated, err := h.store.StatusMerged(body.PullRequestID, override)
+	if err != nil {
+		if errors.Is(err, errs.ErrAlreadyMerged) {
+			pr, err := h.store.GetPR(body.PullRequestID)
+			if err != nil {
+				WriteError(w, err, "Failed to update PR")
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			if err := json.NewEncoder(w).Encode(pr); err != nil {
@@ -162,7 +276,7 @@ func ChangeStatusHandle(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		ErrorJSON(w, http.StatusInternalServerError, "BAD_REQUEST", "Failed to update PR")
+		WriteError(w, err, "Failed to update PR")
 		return
 
 	}
@@ -175,31 +289,95 @@ func ChangeStatusHandle(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func PrCreateHandle(w http.ResponseWriter, r *http.Request) {
-	var pr dbtablesgo.PullRequest
-	err := json.NewDecoder(r.Body).Decode(&pr)
-	if err != nil {
-		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
-		return
-	}
-	if pr.PullRequestID == "" || pr.PullRequestName == "" || pr.AuthorID == "" {
-		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Fields pr_id, pr_name and author_id are required")
-		return
+// transitionHandle serves the lifecycle endpoints that only take a
+// pull_request_id.
+func (h *Handler) transitionHandle(action dbtablesgo.PRAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			PullRequestID string `json:"pull_request_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
+			return
+		}
+		if body.PullRequestID == "" {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Id must be not empty")
+			return
+		}
+		updated, err := h.store.Transition(body.PullRequestID, action)
+		if err != nil {
+			WriteError(w, err, "Failed to update PR")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(updated); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
 	}
+}
 
-	created, err := dbtablesgo.CreatePR(&pr)
-	if err != nil {
-		if err.Error() == "PR_EXISTS" {
-			ErrorJSON(w, http.StatusBadRequest, "PR_EXISTS", "pr is already exists")
+// reviewHandle serves the verdict endpoints of an assigned reviewer.
+func (h *Handler) reviewHandle(verdict string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			PullRequestID string `json:"pull_request_id"`
+			UserID        string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
 			return
-		} else if err.Error() == "AUTHOR_NOT_FOUND" {
-			ErrorJSON(w, http.StatusBadRequest, "AUTHOR_NOT_FOUND", "there no author")
+		}
+		if body.PullRequestID == "" || body.UserID == "" {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "pull_request_id and user_id are required")
+			return
+		}
+		updated, err := h.store.SubmitReview(body.PullRequestID, body.UserID, verdict)
+		if err != nil {
+			WriteError(w, err, "Failed to submit review")
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(updated); err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *Handler) MergeOverridesHandle(w http.ResponseWriter, r *http.Request) {
+	overrides, err := h.store.MergeOverrides(r.URL.Query().Get("pull_request_id"))
+	if err != nil {
+		WriteError(w, err, "problems with getting merge overrides")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"merge_overrides": overrides,
+	}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
 
-		ErrorJSON(w, http.StatusInternalServerError, "BAD_REQUEST", "Failed to create PR")
+func (h *Handler) PrCreateHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := readCreateBody(w, r)
+	if !ok {
 		return
+	}
+	pr := body.PullRequest
+	pr.Status = ""
+	if body.Draft {
+		pr.Status = dbtablesgo.StatusDraft
+	}
 
+	created, err := h.store.CreatePR(&pr, body.StrictReviewers)
+	if err != nil {
+		WriteError(w, err, "Failed to create PR")
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -211,7 +389,51 @@ func PrCreateHandle(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func SetIsActiveHandle(w http.ResponseWriter, r *http.Request) {
+type createBody struct {
+	dbtablesgo.PullRequest
+	StrictReviewers bool `json:"strict_reviewers"`
+	Draft           bool `json:"draft"`
+}
+
+// readCreateBody decodes and validates the body shared by /pullRequest/create
+// and /pullRequest/preview, writing the error response itself.
+func readCreateBody(w http.ResponseWriter, r *http.Request) (createBody, bool) {
+	var body createBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
+		return body, false
+	}
+	pr := body.PullRequest
+	if pr.PullRequestID == "" || pr.PullRequestName == "" || pr.AuthorID == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Fields pr_id, pr_name and author_id are required")
+		return body, false
+	}
+	if pr.RequiredReviewers < 0 {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "required_reviewers must be positive")
+		return body, false
+	}
+	return body, true
+}
+
+func (h *Handler) PrPreviewHandle(w http.ResponseWriter, r *http.Request) {
+	body, ok := readCreateBody(w, r)
+	if !ok {
+		return
+	}
+	preview, err := h.store.PreviewPR(body.PullRequest, body.StrictReviewers)
+	if err != nil {
+		WriteError(w, err, "Failed to preview PR")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(preview); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) SetIsActiveHandle(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		UserID   string `json:"user_id"`
 		IsActive bool   `json:"is_active"`
@@ -226,16 +448,59 @@ func SetIsActiveHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updated, err := dbtablesgo.SetIsActive(body.UserID, body.IsActive)
+	updated, err := h.store.SetIsActive(body.UserID, body.IsActive)
 	if err != nil {
-		if err.Error() == "NOT_FOUND" {
-			ErrorJSON(w, http.StatusBadRequest, "NOT_FOUND", "cant find user")
-			return
+		WriteError(w, err, "some problems with update activity")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
 
-		}
-		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "some problems with update activity ")
+func (h *Handler) GetUserHandle(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "id cant be empty")
+		return
+	}
+	user, err := h.store.GetUser(userID)
+	if err != nil {
+		WriteError(w, err, "problems with getting user")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
+	}
+}
 
+func (h *Handler) SetCapacityHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		UserID         string `json:"user_id"`
+		MaxOpenReviews int    `json:"max_open_reviews"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "cant read json")
+		return
+	}
+	if body.UserID == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Id must be not empty")
+		return
+	}
+	if body.MaxOpenReviews < 0 {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "max_open_reviews must not be negative")
+		return
+	}
+	updated, err := h.store.SetCapacity(body.UserID, body.MaxOpenReviews)
+	if err != nil {
+		WriteError(w, err, "some problems with update capacity")
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -245,7 +510,7 @@ func SetIsActiveHandle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func AddTeamHandle(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) AddTeamHandle(w http.ResponseWriter, r *http.Request) {
 	var team dbtablesgo.Team
 	err := json.NewDecoder(r.Body).Decode(&team)
 	if err != nil {
@@ -261,16 +526,21 @@ func AddTeamHandle(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
-	created, err := dbtablesgo.TeamAdd(team.TeamName, team.Members)
+	if team.ReviewerStrategy != "" && !dbtablesgo.Registered(team.ReviewerStrategy) {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "unknown reviewer_strategy")
+		return
+	}
+	if team.RequiredReviewers < 0 {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "required_reviewers must be positive")
+		return
+	}
+	if !validMembers(w, team.Members) {
+		return
+	}
+	created, err := h.store.TeamAdd(team)
 	if err != nil {
-		if err.Error() == "TEAM_EXISTS" {
-			ErrorJSON(w, http.StatusBadRequest, "TEAM_EXISTS", "team is already exist")
-			return
-
-		}
-		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "some problems with Add team ")
+		WriteError(w, err, "some problems with Add team")
 		return
-
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -283,6 +553,174 @@ func AddTeamHandle(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// validMembers reports a bad member list to w.
+func validMembers(w http.ResponseWriter, members []dbtablesgo.TeamMember) bool {
+	for _, m := range members {
+		if m.UserID == "" {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "user_id cant be empty")
+			return false
+		}
+		if m.MaxOpenReviews < 0 {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "max_open_reviews must not be negative")
+			return false
+		}
+	}
+	return true
+}
+
+// Page size of /team/list.
+const (
+	defaultTeamPage = 20
+	maxTeamPage     = 100
+)
+
+func (h *Handler) ListTeamsHandle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset := defaultTeamPage, 0
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &limit}, {"offset", &offset}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", p.name+" must be a non-negative integer")
+			return
+		}
+		*p.dst = n
+	}
+	if limit == 0 || limit > maxTeamPage {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "limit must be between 1 and 100")
+		return
+	}
+	page, err := h.store.ListTeams(limit, offset)
+	if err != nil {
+		WriteError(w, err, "problems with listing teams")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) AddMembersHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamName string                  `json:"team_name"`
+		Members  []dbtablesgo.TeamMember `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Cant Read json")
+		return
+	}
+	if body.TeamName == "" || len(body.Members) == 0 {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "team_name and members cant be empty")
+		return
+	}
+	if !validMembers(w, body.Members) {
+		return
+	}
+	team, err := h.store.AddMembers(body.TeamName, body.Members)
+	if err != nil {
+		WriteError(w, err, "problems with adding members")
+		return
+	}
+	writeTeam(w, team)
+}
+
+func (h *Handler) RemoveMembersHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamName string   `json:"team_name"`
+		UserIDs  []string `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Cant Read json")
+		return
+	}
+	if body.TeamName == "" || len(body.UserIDs) == 0 {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "team_name and user_ids cant be empty")
+		return
+	}
+	team, err := h.store.RemoveMembers(body.TeamName, body.UserIDs)
+	if err != nil {
+		WriteError(w, err, "problems with removing members")
+		return
+	}
+	writeTeam(w, team)
+}
+
+func (h *Handler) RenameTeamHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamName    string `json:"team_name"`
+		NewTeamName string `json:"new_team_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Cant Read json")
+		return
+	}
+	if body.TeamName == "" || body.NewTeamName == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "team_name and new_team_name cant be empty")
+		return
+	}
+	team, err := h.store.RenameTeam(body.TeamName, body.NewTeamName)
+	if err != nil {
+		WriteError(w, err, "problems with renaming team")
+		return
+	}
+	writeTeam(w, team)
+}
+
+func (h *Handler) DeleteTeamHandle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TeamName string                      `json:"team_name"`
+		Policy   dbtablesgo.TeamDeletePolicy `json:"policy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Cant Read json")
+		return
+	}
+	if body.TeamName == "" {
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "Team name cant be empty")
+		return
+	}
+	switch body.Policy {
+	case "":
+		body.Policy = dbtablesgo.TeamDeleteReject
+	case dbtablesgo.TeamDeleteReject, dbtablesgo.TeamDeleteClose:
+	default:
+		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "policy must be reject or close")
+		return
+	}
+	report, err := h.store.DeleteTeam(body.TeamName, body.Policy)
+	if err != nil {
+		WriteError(w, err, "problems with deleting team")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeTeam(w http.ResponseWriter, team dbtablesgo.Team) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"team": team,
+	}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
 func ErrorJSON(w http.ResponseWriter, status int, code string, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -297,16 +735,16 @@ func ErrorJSON(w http.ResponseWriter, status int, code string, message string) {
 	}
 }
 
-func teamGetHandle(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) teamGetHandle(w http.ResponseWriter, r *http.Request) {
 	teamname := r.URL.Query().Get("team_name")
 	if teamname == "" {
 		ErrorJSON(w, http.StatusBadRequest, "BAD_REQUEST", "id cant be empty")
 		return
 	}
-	team, err := dbtablesgo.GetTeam(teamname)
+	team, err := h.store.GetTeam(teamname)
 
 	if err != nil {
-		ErrorJSON(w, http.StatusNotFound, "NOT_FOUND", "team not found")
+		WriteError(w, err, "problems with getting team")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
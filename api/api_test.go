@@ -0,0 +1,365 @@
+package api_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"avito_otbor/api"
+	dbtablesgo "avito_otbor/dbTablesGo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type backend struct {
+	name  string
+	store func(t *testing.T) dbtablesgo.Store
+}
+
+// backends are the stores every API test runs against: the in-memory one
+// and SQLite in a fresh temp file with all migrations applied. Postgres is
+// added when TEST_POSTGRES_DSN points at a database the tests may create
+// schemas in. All of them take the merge policy from the environment, as
+// DbInit does.
+var backends = []backend{
+	{"memory", newMemoryStore},
+	{"sqlite", newSQLiteStore},
+}
+
+func init() {
+	if os.Getenv("TEST_POSTGRES_DSN") != "" {
+		backends = append(backends, backend{"postgres", newPostgresStore})
+	}
+}
+
+func mergePolicy(t *testing.T) dbtablesgo.MergePolicy {
+	p, err := dbtablesgo.MergePolicyFromEnv()
+	if err != nil {
+		t.Fatalf("merge policy: %v", err)
+	}
+	return p
+}
+
+func newMemoryStore(t *testing.T) dbtablesgo.Store {
+	m := dbtablesgo.NewMemoryStore()
+	m.Policy = mergePolicy(t)
+	return m
+}
+
+func newSQLiteStore(t *testing.T) dbtablesgo.Store {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "pr.db"))
+	s, err := dbtablesgo.OpenSQLStore()
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Db.Close() })
+	s.Policy = mergePolicy(t)
+	migrate(t, s)
+	return s
+}
+
+var schemaSeq int64
+
+// newPostgresStore runs the store in a schema of its own, dropped when the
+// test ends, so tests don't see each other's rows.
+func newPostgresStore(t *testing.T) dbtablesgo.Store {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	root, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { _ = root.Close() })
+	schema := fmt.Sprintf("api_test_%d_%d", os.Getpid(), atomic.AddInt64(&schemaSeq, 1))
+	if _, err := root.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { _, _ = root.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	s := dbtablesgo.NewPostgresStore(db)
+	s.Policy = mergePolicy(t)
+	migrate(t, s)
+	return s
+}
+
+// withSearchPath adds search_path to a URL or key=value DSN; lib/pq sends
+// it to the server as a run-time parameter.
+func withSearchPath(dsn, schema string) string {
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+func migrate(t *testing.T, s *dbtablesgo.SQLStore) {
+	m, err := s.Migrator()
+	if err != nil {
+		t.Fatalf("migrator: %v", err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+func newServer(t *testing.T, store dbtablesgo.Store) *httptest.Server {
+	r := chi.NewRouter()
+	api.Init(r, store)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// admin is a request body sent with an X-Admin-Token header.
+type admin struct {
+	token string
+	body  interface{}
+}
+
+// call sends body as JSON (GET when body is nil) and decodes the reply.
+func call(t *testing.T, srv *httptest.Server, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	token := ""
+	if a, ok := body.(admin); ok {
+		token, body = a.token, a.body
+	}
+	method, payload := http.MethodGet, io.Reader(nil)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshal %s: %v", path, err)
+		}
+		method, payload = http.MethodPost, bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, srv.URL+path, payload)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return 0, nil
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Errorf("%s: decode: %v", path, err)
+	}
+	return resp.StatusCode, out
+}
+
+func errorCode(out map[string]interface{}) string {
+	e, _ := out["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+func addTeam(t *testing.T, srv *httptest.Server, name string, ids ...string) {
+	t.Helper()
+	members := []map[string]interface{}{}
+	for _, id := range ids {
+		members = append(members, map[string]interface{}{"user_id": id, "username": id, "is_active": true})
+	}
+	if status, out := call(t, srv, "/team/add", map[string]interface{}{"team_name": name, "members": members}); status != http.StatusCreated {
+		t.Fatalf("add team %s: %d %v", name, status, out)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	const n = 20
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			srv := newServer(t, b.store(t))
+			addTeam(t, srv, "backend", "u1", "u2", "u3", "u4", "u5")
+
+			t.Run("same id", func(t *testing.T) {
+				statuses := make([]int, n)
+				codes := make([]string, n)
+				var wg sync.WaitGroup
+				for i := 0; i < n; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						var out map[string]interface{}
+						statuses[i], out = call(t, srv, "/pullRequest/create", map[string]interface{}{
+							"pull_request_id": "pr-shared", "pull_request_name": "shared", "author_id": "u1",
+						})
+						codes[i] = errorCode(out)
+					}(i)
+				}
+				wg.Wait()
+				created, conflicts := 0, 0
+				for i := range statuses {
+					switch {
+					case statuses[i] == http.StatusCreated:
+						created++
+					case statuses[i] == http.StatusConflict && codes[i] == "PR_EXISTS":
+						conflicts++
+					default:
+						t.Errorf("unexpected response %d %q", statuses[i], codes[i])
+					}
+				}
+				if created != 1 || conflicts != n-1 {
+					t.Fatalf("got %d created and %d PR_EXISTS, want 1 and %d", created, conflicts, n-1)
+				}
+			})
+
+			t.Run("distinct ids", func(t *testing.T) {
+				statuses := make([]int, n)
+				var wg sync.WaitGroup
+				for i := 0; i < n; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						statuses[i], _ = call(t, srv, "/pullRequest/create", map[string]interface{}{
+							"pull_request_id": fmt.Sprintf("pr-%d", i), "pull_request_name": "own", "author_id": "u1",
+						})
+					}(i)
+				}
+				wg.Wait()
+				for i, status := range statuses {
+					if status != http.StatusCreated {
+						t.Errorf("pr-%d: status %d, want 201", i, status)
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestCreateWhileToggling creates pull requests while teammates are switched
+// off and on. Every request must succeed: a lock-order deadlock or a busy
+// database would surface as a 500 here.
+func TestCreateWhileToggling(t *testing.T) {
+	const n = 20
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			srv := newServer(t, b.store(t))
+			addTeam(t, srv, "backend", "u1", "u2", "u3", "u4", "u5", "u6")
+			addTeam(t, srv, "frontend", "f1", "f2", "f3")
+
+			var wg sync.WaitGroup
+			created := make([]map[string]interface{}, n)
+			for i := 0; i < n; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer wg.Done()
+					author := []string{"u1", "u2"}[i%2]
+					status, out := call(t, srv, "/pullRequest/create", map[string]interface{}{
+						"pull_request_id": fmt.Sprintf("pr-%d", i), "pull_request_name": "toggle", "author_id": author,
+					})
+					if status != http.StatusCreated {
+						t.Errorf("create pr-%d: %d %v", i, status, out)
+					}
+					created[i] = out
+				}(i)
+				go func(i int) {
+					defer wg.Done()
+					user := []string{"u3", "u4", "u5", "f1"}[i%4]
+					status, out := call(t, srv, "/users/setIsActive", map[string]interface{}{"user_id": user, "is_active": i%8 >= 4})
+					if status != http.StatusOK {
+						t.Errorf("setIsActive %s: %d %v", user, status, out)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			// Whatever the final activity is, the load reported per user
+			// must match what was assigned.
+			assigned := map[string]int{}
+			for i, out := range created {
+				reviewers, _ := out["assigned_reviewers"].([]interface{})
+				if len(reviewers) != 2 {
+					t.Errorf("pr-%d: reviewers %v, want 2", i, reviewers)
+				}
+				for _, r := range reviewers {
+					if r == out["author_id"] {
+						t.Errorf("pr-%d: author %v reviews their own pull request", i, r)
+					}
+					assigned[fmt.Sprint(r)]++
+				}
+			}
+			for _, id := range []string{"u1", "u2", "u3", "u4", "u5", "u6"} {
+				_, out := call(t, srv, "/users/get?user_id="+id, nil)
+				if got := out["open_reviews"]; got != float64(assigned[id]) {
+					t.Errorf("%s: open_reviews %v, want %d", id, got, assigned[id])
+				}
+			}
+		})
+	}
+}
+
+// TestSeedsAreStrings checks that seeds survive JSON clients that read
+// numbers as float64, and that every drawn reviewer carries their own draw.
+func TestSeedsAreStrings(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			srv := newServer(t, b.store(t))
+			addTeam(t, srv, "backend", "u1", "u2", "u3", "u4")
+			status, out := call(t, srv, "/pullRequest/create", map[string]interface{}{
+				"pull_request_id": "p1", "pull_request_name": "seeds", "author_id": "u1",
+			})
+			if status != http.StatusCreated {
+				t.Fatalf("create: %d %v", status, out)
+			}
+			assignment, _ := out["assignment"].(map[string]interface{})
+			seed, ok := assignment["seed"].(string)
+			if _, err := strconv.ParseInt(seed, 10, 64); !ok || err != nil {
+				t.Fatalf("assignment.seed = %#v, want an int64 in a string", assignment["seed"])
+			}
+			draws, _ := assignment["draws"].(map[string]interface{})
+			reviewers, _ := out["assigned_reviewers"].([]interface{})
+			for _, r := range reviewers {
+				d, _ := draws[r.(string)].(map[string]interface{})
+				if d["seed"] != seed || d["strategy"] != assignment["strategy"] {
+					t.Errorf("draw of %v = %v, want the creation draw", r, d)
+				}
+			}
+
+			old := reviewers[0].(string)
+			status, out = call(t, srv, "/pullRequest/reassign", map[string]interface{}{"pull_request_id": "p1", "old_user_id": old})
+			if status != http.StatusOK {
+				t.Fatalf("reassign: %d %v", status, out)
+			}
+			newID := out["new_reviewer"].(map[string]interface{})["user_id"].(string)
+			assignment = out["pr"].(map[string]interface{})["assignment"].(map[string]interface{})
+			draws = assignment["draws"].(map[string]interface{})
+			d, _ := draws[newID].(map[string]interface{})
+			if s, ok := d["seed"].(string); !ok || s == seed {
+				t.Errorf("draw of %s = %v, want a seed string of its own", newID, d)
+			}
+			if _, ok := draws[old]; ok {
+				t.Errorf("draw of replaced %s is still there", old)
+			}
+			if reason := assignment["reasons"].(map[string]interface{})[newID].(string); strings.Contains(reason, "seed") {
+				t.Errorf("reason %q still carries the seed", reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package api_test
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type obj = map[string]interface{}
+
+// step is one request of a scenario and what the API must answer. A nil body
+// sends a GET; code is the expected error code, if any.
+type step struct {
+	path   string
+	body   interface{}
+	status int
+	code   string
+	checks []check
+}
+
+// check compares the value found at keys in the response with want.
+type check struct {
+	keys []string
+	want string
+}
+
+func field(want string, keys ...string) check { return check{keys, want} }
+
+// lookup walks out along keys. Array elements are addressed by index and "#"
+// is the array length; lists are sorted so that checks don't depend on
+// assignment order.
+func lookup(out interface{}, keys []string) string {
+	for _, k := range keys {
+		if v, ok := out.([]interface{}); ok && k == "#" {
+			return fmt.Sprint(len(v))
+		}
+		switch v := out.(type) {
+		case map[string]interface{}:
+			out = v[k]
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscan(k, &i); err != nil || i >= len(v) {
+				return "<missing>"
+			}
+			out = v[i]
+		default:
+			return "<missing>"
+		}
+	}
+	if list, ok := out.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		sort.Strings(items)
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return fmt.Sprint(out)
+}
+
+func member(id string, active bool) obj {
+	return obj{"user_id": id, "username": id, "is_active": active}
+}
+
+// team builds a /team/add body with least_loaded selection, which is
+// deterministic: ties go to the smaller user_id.
+func team(name string, ids ...string) obj {
+	members := []interface{}{}
+	for _, id := range ids {
+		members = append(members, member(id, true))
+	}
+	return obj{"team_name": name, "members": members, "reviewer_strategy": "least_loaded"}
+}
+
+func pr(id, author string) obj {
+	return obj{"pull_request_id": id, "pull_request_name": id, "author_id": author}
+}
+
+// scenario is a sequence of steps run on a fresh store; env is set before
+// the store and the handler read their configuration.
+type scenario struct {
+	name  string
+	env   map[string]string
+	steps []step
+}
+
+var contract = []scenario{
+	{"teams", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", []check{
+			field("backend", "team", "team_name"),
+			field("3", "team", "members", "#"),
+		}},
+		{"/team/add", team("backend", "u4"), http.StatusBadRequest, "TEAM_EXISTS", nil},
+		{"/team/get?team_name=backend", nil, http.StatusOK, "", []check{field("3", "members", "#")}},
+		{"/team/get?team_name=ghost", nil, http.StatusNotFound, "NOT_FOUND", nil},
+		{"/users/get?user_id=u2", nil, http.StatusOK, "", []check{field("backend", "team_name")}},
+		{"/team/list", nil, http.StatusOK, "", []check{field("1", "total")}},
+	}},
+	{"create", nil, []step{
+		{"/team/add", obj{"team_name": "backend", "reviewer_strategy": "least_loaded", "members": []interface{}{
+			member("u1", true), member("u2", true), member("u3", true), member("u4", false),
+		}}, http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{
+			field("OPEN", "status"),
+			field("[u2 u3]", "assigned_reviewers"),
+			field("least_loaded", "assignment", "strategy"),
+		}},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusConflict, "PR_EXISTS", nil},
+		{"/pullRequest/create", pr("p2", "ghost"), http.StatusNotFound, "AUTHOR_NOT_FOUND", nil},
+		{"/users/getReview?user_id=u2", nil, http.StatusOK, "", []check{field("p1", "pull_requests", "0", "pull_request_id")}},
+	}},
+	{"preview matches create", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/pullRequest/preview", pr("p1", "u1"), http.StatusOK, "", []check{
+			field("[u2 u3]", "assigned_reviewers"),
+			field("author", "excluded", "0", "reason"),
+		}},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+	}},
+	{"reassign", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/reassign", obj{"pull_request_id": "p1", "old_user_id": "u4"}, http.StatusConflict, "NOT_ASSIGNED", nil},
+		{"/pullRequest/reassign", obj{"pull_request_id": "p1", "old_user_id": "u2", "new_user_id": "u1"},
+			http.StatusConflict, "REVIEWER_NOT_ELIGIBLE", []check{field("[author]", "error", "details")}},
+		{"/pullRequest/reassign", obj{"pull_request_id": "p1", "old_user_id": "u2"}, http.StatusOK, "", []check{
+			field("u2", "old_reviewer", "user_id"),
+			field("u4", "new_reviewer", "user_id"),
+			field("[u3 u4]", "pr", "assigned_reviewers"),
+		}},
+	}},
+	{"merge", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/requestChanges", obj{"pull_request_id": "p1", "user_id": "u2"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusConflict, "MERGE_BLOCKED",
+			[]check{field("[NO_CHANGE_REQUESTS]", "error", "details")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p1", "user_id": "u4"}, http.StatusConflict, "NOT_ASSIGNED", nil},
+		{"/pullRequest/approve", obj{"pull_request_id": "p1", "user_id": "u2"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{field("MERGED", "status")}},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{field("MERGED", "status")}},
+		{"/pullRequest/reassign", obj{"pull_request_id": "p1", "old_user_id": "u2"}, http.StatusConflict, "PR_MERGED", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "ghost"}, http.StatusNotFound, "NOT_FOUND", nil},
+	}},
+	{"lead approval", map[string]string{"MERGE_MIN_APPROVALS": "1", "MERGE_REQUIRE_LEAD": "true"}, []step{
+		{"/team/add", obj{"team_name": "backend", "reviewer_strategy": "least_loaded", "members": []interface{}{
+			member("u1", true), member("u2", true), member("u3", true), member("u4", true),
+			obj{"user_id": "u5", "username": "u5", "is_active": true, "is_lead": true},
+		}}, http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u5"), http.StatusCreated, "", []check{field("[u1 u2]", "assigned_reviewers")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p1", "user_id": "u5"}, http.StatusConflict, "REVIEWER_NOT_ELIGIBLE",
+			[]check{field("[author]", "error", "details")}},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusConflict, "MERGE_BLOCKED",
+			[]check{field("[APPROVALS LEAD_APPROVAL]", "error", "details")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p1", "user_id": "u1"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusConflict, "MERGE_BLOCKED",
+			[]check{field("[LEAD_APPROVAL]", "error", "details")}},
+		{"/pullRequest/create", pr("p2", "u1"), http.StatusCreated, "", []check{field("[u3 u4]", "assigned_reviewers")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p2", "user_id": "u5"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p2"}, http.StatusConflict, "MERGE_BLOCKED",
+			[]check{field("[APPROVALS]", "error", "details")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p2", "user_id": "u3"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p2"}, http.StatusOK, "", []check{field("MERGED", "status")}},
+	}},
+	{"lifecycle", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", obj{"pull_request_id": "p1", "pull_request_name": "p1", "author_id": "u1", "draft": true},
+			http.StatusCreated, "", []check{field("DRAFT", "status"), field("[]", "assigned_reviewers")}},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusConflict, "INVALID_TRANSITION", nil},
+		{"/pullRequest/ready", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{
+			field("OPEN", "status"), field("[u2 u3]", "assigned_reviewers"),
+		}},
+		{"/pullRequest/close", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{field("CLOSED", "status")}},
+		{"/pullRequest/reassign", obj{"pull_request_id": "p1", "old_user_id": "u2"}, http.StatusConflict, "PR_NOT_OPEN", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1"}, http.StatusConflict, "INVALID_TRANSITION", nil},
+		{"/pullRequest/reopen", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{field("OPEN", "status")}},
+	}},
+	{"reopen replaces stale reviewers", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4", "u5"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+		{"/pullRequest/approve", obj{"pull_request_id": "p1", "user_id": "u3"}, http.StatusOK, "", nil},
+		{"/pullRequest/close", obj{"pull_request_id": "p1"}, http.StatusOK, "", nil},
+		{"/users/deactivateMany", obj{"user_ids": []string{"u2", "u3"}, "mode": "strict"}, http.StatusOK, "", []check{
+			field("[]", "pull_requests"),
+		}},
+		{"/pullRequest/reopen", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{
+			field("OPEN", "status"),
+			field("[u4 u5]", "assigned_reviewers"),
+			field("false", "understaffed"),
+			field("[]", "reviews"),
+			field("least_loaded", "assignment", "draws", "u4", "strategy"),
+		}},
+	}},
+	{"reopen without replacements", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+		{"/pullRequest/close", obj{"pull_request_id": "p1"}, http.StatusOK, "", nil},
+		{"/team/removeMembers", obj{"team_name": "backend", "user_ids": []string{"u3"}}, http.StatusOK, "", nil},
+		{"/users/setIsActive", obj{"user_id": "u2", "is_active": false}, http.StatusOK, "", nil},
+		{"/pullRequest/reopen", obj{"pull_request_id": "p1"}, http.StatusOK, "", []check{
+			field("[u4]", "assigned_reviewers"),
+			field("true", "understaffed"),
+		}},
+	}},
+	{"capacity", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/users/setCapacity", obj{"user_id": "u2", "max_open_reviews": 1}, http.StatusOK, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+		{"/pullRequest/create", pr("p2", "u3"), http.StatusCreated, "", []check{field("[u1 u4]", "assigned_reviewers")}},
+		{"/users/get?user_id=u2", nil, http.StatusOK, "", []check{field("1", "open_reviews")}},
+	}},
+	{"deactivate many", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/users/deactivateMany", obj{"user_ids": []string{"u2"}, "mode": "strict"},
+			http.StatusConflict, "NO_REVIEWERS", []check{field("[p1 u2]", "error", "ids")}},
+		{"/users/get?user_id=u2", nil, http.StatusOK, "", []check{field("true", "is_active")}},
+		{"/users/deactivateMany", obj{"user_ids": []string{"u2", "ghost"}, "mode": "lenient"}, http.StatusOK, "", []check{
+			field("[u2]", "deactivated"),
+			field("[ghost]", "unknown"),
+			field("p1", "pull_requests", "0", "pull_request_id"),
+		}},
+		{"/users/getReview?user_id=u2", nil, http.StatusNotFound, "NOT_FOUND", nil},
+	}},
+	{"decline and hand over", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4", "u5"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/decline", obj{"pull_request_id": "p1", "user_id": "u2", "reason": "busy"}, http.StatusOK, "", []check{
+			field("u4", "new_reviewer", "user_id"),
+			field("busy", "decline", "reason"),
+		}},
+		{"/users/handOver", obj{"user_id": "u3", "successor_id": "u1"}, http.StatusOK, "", []check{
+			field("u2", "pull_requests", "0", "replaced_by"),
+			field("author", "pull_requests", "0", "successor_skipped"),
+			field("[u2 u4]", "pull_requests", "0", "assigned_reviewers"),
+		}},
+		{"/users/handOver", obj{"user_id": "u3"}, http.StatusOK, "", []check{field("[]", "pull_requests")}},
+		{"/stats", nil, http.StatusOK, "", []check{field("1", "declines_by_user", "u2")}},
+	}},
+	{"stats", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p2", "u2"), http.StatusCreated, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p2"}, http.StatusOK, "", nil},
+		{"/stats", nil, http.StatusOK, "", []check{
+			field("2", "pull_requests"),
+			field("1", "open_prs"),
+			field("1", "merged_prs"),
+			field("2", "assignments_by_user", "u3"),
+			field("4", "assignments_by_team", "backend"),
+		}},
+	}},
+	{"team management", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/team/removeMembers", obj{"team_name": "backend", "user_ids": []string{"u4"}}, http.StatusOK, "", []check{
+			field("3", "team", "members", "#"),
+		}},
+		{"/team/removeMembers", obj{"team_name": "backend", "user_ids": []string{"u4"}},
+			http.StatusNotFound, "NOT_FOUND", []check{field("[u4]", "error", "ids")}},
+		{"/pullRequest/create", pr("solo", "u4"), http.StatusCreated, "", []check{field("[]", "assigned_reviewers")}},
+		{"/team/add", team("frontend", "u5"), http.StatusCreated, "", nil},
+		{"/team/rename", obj{"team_name": "backend", "new_team_name": "frontend"}, http.StatusBadRequest, "TEAM_EXISTS", nil},
+		{"/team/rename", obj{"team_name": "backend", "new_team_name": "core"}, http.StatusOK, "", []check{
+			field("core", "team", "team_name"),
+		}},
+		{"/users/get?user_id=u1", nil, http.StatusOK, "", []check{field("core", "team_name")}},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/team/delete", obj{"team_name": "core"}, http.StatusConflict, "TEAM_HAS_OPEN_PRS", []check{field("[core p1]", "error", "ids")}},
+		{"/team/delete", obj{"team_name": "core", "policy": "close"}, http.StatusOK, "", []check{field("[p1]", "closed_prs")}},
+		{"/team/get?team_name=core", nil, http.StatusNotFound, "NOT_FOUND", nil},
+		{"/team/list", nil, http.StatusOK, "", []check{field("1", "total")}},
+	}},
+	{"set is active", nil, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3", "u4"), http.StatusCreated, "", nil},
+		{"/users/setIsActive", obj{"user_id": "u2", "is_active": false}, http.StatusOK, "", []check{
+			field("u2", "user_id"), field("false", "is_active"),
+		}},
+		{"/users/get?user_id=u2", nil, http.StatusOK, "", []check{field("false", "is_active")}},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u3 u4]", "assigned_reviewers")}},
+		{"/users/setIsActive", obj{"user_id": "u2", "is_active": true}, http.StatusOK, "", []check{field("true", "is_active")}},
+		{"/pullRequest/create", pr("p2", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+		{"/users/setIsActive", obj{"user_id": "ghost", "is_active": false}, http.StatusNotFound, "NOT_FOUND", nil},
+		{"/users/setIsActive", obj{"is_active": false}, http.StatusBadRequest, "BAD_REQUEST", nil},
+	}},
+	{"add members", nil, []step{
+		{"/team/add", team("backend", "u1", "u2"), http.StatusCreated, "", nil},
+		{"/team/add", team("frontend", "u3", "u5"), http.StatusCreated, "", nil},
+		{"/team/addMembers", obj{"team_name": "backend", "members": []interface{}{member("u3", true), member("u4", false)}},
+			http.StatusOK, "", []check{field("backend", "team", "team_name"), field("4", "team", "members", "#")}},
+		{"/users/get?user_id=u3", nil, http.StatusOK, "", []check{field("backend", "team_name")}},
+		{"/team/get?team_name=frontend", nil, http.StatusOK, "", []check{field("1", "members", "#")}},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", []check{field("[u2 u3]", "assigned_reviewers")}},
+		{"/team/addMembers", obj{"team_name": "ghost", "members": []interface{}{member("u6", true)}}, http.StatusNotFound, "NOT_FOUND", nil},
+		{"/team/addMembers", obj{"team_name": "backend", "members": []interface{}{}}, http.StatusBadRequest, "BAD_REQUEST", nil},
+	}},
+	{"merge override", map[string]string{"ADMIN_TOKEN": "secret"}, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/requestChanges", obj{"pull_request_id": "p1", "user_id": "u2"}, http.StatusOK, "", nil},
+		{"/pullRequest/merge", obj{"pull_request_id": "p1", "override": true, "actor": "admin", "reason": "hotfix"},
+			http.StatusForbidden, "FORBIDDEN", nil},
+		{"/pullRequest/merge", admin{"wrong", obj{"pull_request_id": "p1", "override": true, "actor": "admin", "reason": "hotfix"}},
+			http.StatusForbidden, "FORBIDDEN", nil},
+		{"/pullRequest/merge", admin{"secret", obj{"pull_request_id": "p1", "override": true, "actor": "admin"}},
+			http.StatusBadRequest, "BAD_REQUEST", nil},
+		{"/pullRequest/mergeOverrides", nil, http.StatusOK, "", []check{field("0", "merge_overrides", "#")}},
+		{"/pullRequest/merge", admin{"secret", obj{"pull_request_id": "p1", "override": true, "actor": "admin", "reason": "hotfix"}},
+			http.StatusOK, "", []check{field("MERGED", "status")}},
+		{"/pullRequest/mergeOverrides?pull_request_id=p1", nil, http.StatusOK, "", []check{
+			field("1", "merge_overrides", "#"),
+			field("admin", "merge_overrides", "0", "actor"),
+			field("hotfix", "merge_overrides", "0", "reason"),
+			field("[NO_CHANGE_REQUESTS]", "merge_overrides", "0", "missing"),
+		}},
+		{"/pullRequest/mergeOverrides?pull_request_id=p2", nil, http.StatusOK, "", []check{field("0", "merge_overrides", "#")}},
+	}},
+	{"merge override disabled", map[string]string{"ADMIN_TOKEN": ""}, []step{
+		{"/team/add", team("backend", "u1", "u2", "u3"), http.StatusCreated, "", nil},
+		{"/pullRequest/create", pr("p1", "u1"), http.StatusCreated, "", nil},
+		{"/pullRequest/merge", admin{"secret", obj{"pull_request_id": "p1", "override": true, "actor": "admin", "reason": "hotfix"}},
+			http.StatusForbidden, "FORBIDDEN", nil},
+		{"/pullRequest/mergeOverrides", nil, http.StatusOK, "", []check{field("0", "merge_overrides", "#")}},
+	}},
+}
+
+// TestContract runs every scenario against each backend, so MemoryStore and
+// SQLStore can't drift apart in what the API returns.
+func TestContract(t *testing.T) {
+	for _, sc := range contract {
+		for _, b := range backends {
+			t.Run(sc.name+"/"+b.name, func(t *testing.T) {
+				for k, v := range sc.env {
+					t.Setenv(k, v)
+				}
+				srv := newServer(t, b.store(t))
+				for i, s := range sc.steps {
+					status, out := call(t, srv, s.path, s.body)
+					if status != s.status || errorCode(out) != s.code {
+						t.Fatalf("step %d %s: got %d %q, want %d %q: %v", i, s.path, status, errorCode(out), s.status, s.code, out)
+					}
+					for _, c := range s.checks {
+						if got := lookup(out, c.keys); got != c.want {
+							t.Errorf("step %d %s: %s = %s, want %s", i, s.path, strings.Join(c.keys, "."), got, c.want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
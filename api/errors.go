@@ -0,0 +1,63 @@
+package api
+
+import (
+	"avito_otbor/errs"
+	"encoding/json"
+	"net/http"
+)
+
+var errorStatus = map[string]int{
+	errs.ErrNotFound.Code:           http.StatusNotFound,
+	errs.ErrAuthorNotFound.Code:     http.StatusNotFound,
+	errs.ErrTeamExists.Code:         http.StatusBadRequest,
+	errs.ErrTeamInUse.Code:          http.StatusConflict,
+	errs.ErrPRExists.Code:           http.StatusConflict,
+	errs.ErrAlreadyMerged.Code:      http.StatusConflict,
+	errs.ErrPRMerged.Code:           http.StatusConflict,
+	errs.ErrNotAssigned.Code:        http.StatusConflict,
+	errs.ErrNoReplacement.Code:      http.StatusConflict,
+	errs.ErrNotEligible.Code:        http.StatusConflict,
+	errs.ErrNoReviewers.Code:        http.StatusConflict,
+	errs.ErrNotEnoughReviewers.Code: http.StatusConflict,
+	errs.ErrInvalidTransition.Code:  http.StatusConflict,
+	errs.ErrNotOpen.Code:            http.StatusConflict,
+	errs.ErrMergeBlocked.Code:       http.StatusConflict,
+}
+
+// WriteError maps a domain error to its HTTP status and error code. Anything
+// that is not a domain error is reported as INTERNAL_ERROR with fallback as
+// the message.
+func WriteError(w http.ResponseWriter, err error, fallback string) {
+	if e, ok := errs.As(err); ok {
+		status, ok := errorStatus[e.Code]
+		if !ok {
+			status = http.StatusBadRequest
+		}
+		writeDomainError(w, status, e)
+		return
+	}
+	ErrorJSON(w, http.StatusInternalServerError, "INTERNAL_ERROR", fallback)
+}
+
+// writeDomainError is ErrorJSON with the affected ids and the details of e
+// added to the error when there are any.
+func writeDomainError(w http.ResponseWriter, status int, e *errs.Error) {
+	body := map[string]interface{}{
+		"code":    e.Code,
+		"message": e.Message,
+	}
+	if len(e.IDs) > 0 {
+		body["ids"] = e.IDs
+	}
+	if len(e.Details) > 0 {
+		body["details"] = e.Details
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": body,
+	}); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
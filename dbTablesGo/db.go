@@ -2,136 +2,304 @@ package dbtablesgo
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"math/rand"
 	"os"
 	"time"
 
-	"github.com/lib/pq"
+	"avito_otbor/dbTablesGo/migrations"
+	"avito_otbor/errs"
 )
 
 const (
 	Format = "20060102"
 )
 
-var (
-	Db *sql.DB
-)
-
-type TeamMember struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	IsActive bool   `json:"is_active"`
+type SQLStore struct {
+	Db       *sql.DB
+	Assigner *Assigner
+	Policy   MergePolicy
+	d        dialect
 }
 
-type Team struct {
-	TeamName string       `json:"team_name"`
-	Members  []TeamMember `json:"members"`
+var _ Store = (*SQLStore)(nil)
+
+func DbInit() (Store, error) {
+	assigner, err := AssignerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := MergePolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("DB_DRIVER") == "memory" {
+		m := NewMemoryStore()
+		m.Assigner = assigner
+		m.Policy = policy
+		return m, nil
+	}
+	s, err := OpenSQLStore()
+	if err != nil {
+		return nil, err
+	}
+	s.Assigner = assigner
+	s.Policy = policy
+	m, err := s.Migrator()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.Up(); err != nil {
+		return nil, err
+	}
+	return s, nil
+
 }
 
-type User struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	TeamName string `json:"team_name"`
-	IsActive bool   `json:"is_active"`
+// OpenSQLStore connects to the database chosen by DB_DRIVER without
+// touching the schema.
+func OpenSQLStore() (*SQLStore, error) {
+	var s *SQLStore
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "postgres":
+		db, err := sql.Open("postgres", GetConnection())
+		if err != nil {
+			return nil, err
+		}
+		s = NewPostgresStore(db)
+	case "sqlite":
+		db, err := sql.Open("sqlite", GetSQLitePath())
+		if err != nil {
+			return nil, err
+		}
+		s = NewSQLiteStore(db)
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+	if err := s.Db.Ping(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-type PullRequest struct {
-	PullRequestID     string     `json:"pull_request_id"`
-	PullRequestName   string     `json:"pull_request_name"`
-	AuthorID          string     `json:"author_id"`
-	Status            string     `json:"status"`
-	AssignedReviewers []string   `json:"assigned_reviewers"`
-	CreatedAt         time.Time  `json:"createdAt,omitempty"`
-	MergedAt          *time.Time `json:"mergedAt,omitempty"`
+func (s *SQLStore) Migrator() (*migrations.Migrator, error) {
+	return migrations.New(s.Db, s.d.name())
 }
 
-type Stats struct {
-	AssignmentsByUser map[string]int `json:"assignments_by_user"`
-	AssignmentsByPR   map[string]int `json:"assignments_by_pr"`
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-func GetConnection() string {
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	pass := os.Getenv("DB_PASS")
-	name := os.Getenv("DB_NAME")
+// prColumns is the column list read by scanPR.
+const prColumns = `pr_id, pr_name, author_id, status, assigned_reviewers, created_at, merged_at,
+    closed_at, required_reviewers, assignment, reviews`
 
-	return fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		user, pass, host, port, name,
-	)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func DbInit() error {
-	conn := GetConnection()
-	var err error
-	Db, err = sql.Open("postgres", conn)
-	if err != nil {
-		return err
+func (s *SQLStore) scanPR(row rowScanner) (PullRequest, error) {
+	var pr PullRequest
+	err := row.Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status,
+		s.d.scanArray(&pr.AssignedReviewers), &pr.CreatedAt, &pr.MergedAt, &pr.ClosedAt, &pr.RequiredReviewers,
+		jsonColumn{dst: &pr.Assignment}, jsonColumn{dst: &pr.Reviews})
+	if pr.AssignedReviewers == nil {
+		pr.AssignedReviewers = []string{}
 	}
-	err = Db.Ping()
+	if pr.Reviews == nil {
+		pr.Reviews = []Review{}
+	}
+	pr.checkStaffed()
+	return pr, err
+}
+
+// jsonColumn keeps a value as JSON text: v is written and dst is scanned
+// into. NULL leaves dst untouched.
+type jsonColumn struct {
+	v   interface{}
+	dst interface{}
+}
+
+func (c jsonColumn) Value() (driver.Value, error) {
+	b, err := json.Marshal(c.v)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = CreateTables()
-	return err
+	return string(b), nil
+}
 
+func (c jsonColumn) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c.dst)
+	case []byte:
+		return json.Unmarshal(v, c.dst)
+	}
+	return fmt.Errorf("cannot scan %T into %T", src, c.dst)
 }
-func selectReviewer(tx *sql.Tx, authorID string) (string, error) {
-	var teamID string
-	err := tx.QueryRow(`SELECT team_id FROM users WHERE id = $1`, authorID).Scan(&teamID)
+
+// openLoad counts OPEN pull requests per assigned reviewer.
+func (s *SQLStore) openLoad(q querier) (map[string]int, error) {
+	rows, err := q.Query(`SELECT assigned_reviewers FROM pull_requests WHERE status = $1`, StatusOpen)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer rows.Close()
+	load := make(map[string]int)
+	for rows.Next() {
+		var reviewers []string
+		if err := rows.Scan(s.d.scanArray(&reviewers)); err != nil {
+			return nil, fmt.Errorf("scan reviewers: %w", err)
+		}
+		for _, id := range reviewers {
+			load[id]++
+		}
 	}
+	return load, rows.Err()
+}
 
-	rows, err := tx.Query(`
-        SELECT id 
-        FROM users 
-        WHERE team_id = $1 AND is_active = true AND id != $2
-    `, teamID, authorID)
+// reviewPool loads the author's team, its reviewer settings and the active
+// teammates that may review, leaving out the author and everyone in exclude.
+// Candidates come ordered by user_id and without OpenReviews filled in, the
+// teammates left out are listed in Excluded. For
+// rotating teams the team row is locked so that concurrent picks move the
+// cursor one after another; see saveCursor. The teammate rows are locked
+// too, so the load read after reviewPool stays valid until commit and two
+// transactions can't both fill the last free slot of a capped reviewer.
+// lock is appended to both queries; a dry run passes "" so it doesn't block
+// writers.
+func (s *SQLStore) reviewPool(q querier, authorID string, exclude []string, lock string) (*reviewPool, error) {
+	pool := &reviewPool{}
+	err := q.QueryRow(`
+        SELECT COALESCE(u.team_name, ''), COALESCE(t.reviewer_strategy, ''), COALESCE(t.required_reviewers, 0)
+        FROM users u
+        LEFT JOIN teams t ON t.team_name = u.team_name
+        WHERE u.user_id = $1`, authorID).Scan(&pool.Team, &pool.Strategy, &pool.Required)
+	if err == sql.ErrNoRows {
+		return nil, errs.ErrAuthorNotFound.WithIDs(authorID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Assigner.rotates(pool.Strategy) {
+		err = q.QueryRow(`SELECT COALESCE(rotation_cursor, '') FROM teams WHERE team_name = $1`+lock,
+			pool.Team).Scan(&pool.Cursor)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	rows, err := q.Query(`
+        SELECT user_id, is_active, review_weight, COALESCE(max_open_reviews, 0)
+        FROM users
+        WHERE team_name = $1
+        ORDER BY user_id`+lock, pool.Team)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var reviewers []string
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return "", fmt.Errorf("scan id: %w", err)
+		var c Candidate
+		var active bool
+		if err := rows.Scan(&c.UserID, &active, &c.Weight, &c.Capacity); err != nil {
+			return nil, fmt.Errorf("scan candidate: %w", err)
 		}
-		reviewers = append(reviewers, id)
+		pool.add(c, active, authorID, exclude)
 	}
+	return pool, rows.Err()
+}
 
-	if len(reviewers) == 0 {
-		return "", errors.New("NO_REVIEWERS")
-	}
-	rows2, err := tx.Query(`
-        SELECT reviewer_id, COUNT(*) as cnt
-        FROM pull_requests
-        WHERE reviewer_id = ANY($1)
-        GROUP BY reviewer_id
-        ORDER BY cnt ASC
-        LIMIT 1
-    `, pq.Array(reviewers))
+// lockPools locks the teams of the users in ids and all their teammates, for
+// batches that read the load before picking. Locks are taken in the order
+// reviewPool takes them, team rows first and then users by user_id, so a
+// batch and a single pick can't wait for each other in a cycle.
+func (s *SQLStore) lockPools(tx *sql.Tx, ids []string) error {
+	_, err := tx.Exec(`
+        SELECT team_name FROM teams
+        WHERE team_name IN (SELECT team_name FROM users WHERE `+s.d.inArray("user_id", "$1")+`)
+        ORDER BY team_name`+s.d.forUpdate(), s.d.array(ids))
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer rows2.Close()
+	_, err = tx.Exec(`
+        SELECT user_id FROM users
+        WHERE team_name IN (SELECT team_name FROM users WHERE `+s.d.inArray("user_id", "$1")+`)
+        ORDER BY user_id`+s.d.forUpdate(), s.d.array(ids))
+	return err
+}
+
+// saveCursor stores the rotation cursor of p if pick moved it.
+func (s *SQLStore) saveCursor(q querier, p *reviewPool) error {
+	if !p.moved {
+		return nil
+	}
+	_, err := q.Exec(`UPDATE teams SET rotation_cursor = $1 WHERE team_name = $2`, p.Cursor, p.Team)
+	return err
+}
+
+// restaff replaces every reviewer of pr listed in removed; cause, such as
+// "deactivated", goes into the reason of the replacement. pool returns the
+// possible reviewers that are not in exclude and save keeps the team's
+// rotation cursor after a pick. In lenient mode a reviewer without a
+// replacement is dropped instead of failing with NO_REVIEWERS.
+func restaff(a *Assigner, pr *PullRequest, removed []string, cause string, mode DeactivationMode, load map[string]int,
+	pool func(exclude []string) (*reviewPool, error), save func(p *reviewPool) error) (AffectedPR, error) {
+	affected := AffectedPR{PullRequestID: pr.PullRequestID, AuthorID: pr.AuthorID}
+	kept := []string{}
+	for _, old := range pr.AssignedReviewers {
+		if !contains(removed, old) {
+			kept = append(kept, old)
+			continue
+		}
+		exclude := append(append([]string{}, pr.AssignedReviewers...), kept...)
+		p, err := pool(exclude)
+		if err != nil {
+			return AffectedPR{}, err
+		}
+		picked, dr := a.pick(p, load, 1)
+		if err := save(p); err != nil {
+			return AffectedPR{}, err
+		}
+		if pr.Assignment == nil {
+			pr.Assignment = &Assignment{Strategy: dr.Strategy}
+		}
+		if len(picked) == 0 {
+			if mode != DeactivateLenient {
+				return AffectedPR{}, errs.ErrNoReviewers.WithIDs(pr.PullRequestID, old)
+			}
+			pr.Assignment.replace(old, "", "", Draw{})
+			pr.dropReview(old)
+			affected.Replacements = append(affected.Replacements, Replacement{RemovedReviewerID: old, LeftEmpty: true})
+			affected.Understaffed = true
+			continue
+		}
+		reviewer := picked[0].UserID
+		pr.Assignment.replace(old, reviewer, fmt.Sprintf("replaces %s %s; %s", cause, old, picked[0].Reason), dr)
+		pr.dropReview(old)
+		load[reviewer]++
+		kept = append(kept, reviewer)
+		affected.Replacements = append(affected.Replacements, Replacement{RemovedReviewerID: old, ReplacedBy: reviewer})
+	}
+	pr.AssignedReviewers = kept
+	pr.checkStaffed()
+	affected.Understaffed = affected.Understaffed || pr.Understaffed
+	affected.AssignedReviewers = append([]string{}, kept...)
+	return affected, nil
+}
 
-	if rows2.Next() {
-		var reviewerID string
-		var cnt int
-		if err := rows2.Scan(&reviewerID, &cnt); err != nil {
-			return "", fmt.Errorf("scan reviewer stats: %w", err)
+func prAuthors(prs []PullRequest) []string {
+	ids := []string{}
+	for _, pr := range prs {
+		if !contains(ids, pr.AuthorID) {
+			ids = append(ids, pr.AuthorID)
 		}
-		return reviewerID, nil
 	}
-	return reviewers[0], nil
+	return ids
 }
 
 func contains(arr []string, target string) bool {
@@ -143,145 +311,209 @@ func contains(arr []string, target string) bool {
 	return false
 }
 
-func DeactivateManyUsers(ids []string) error {
-	tx, err := Db.Begin()
+func (s *SQLStore) DeactivateManyUsers(ids []string, mode DeactivationMode) (*DeactivationReport, error) {
+	tx, err := s.Db.Begin()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err = tx.Exec(`UPDATE users SET is_active = false WHERE id = ANY($1)`, pq.Array(ids))
+	// The teams of the deactivated users are where their replacements come
+	// from, so their pools are locked before the users themselves.
+	if err := s.lockPools(tx, ids); err != nil {
+		return nil, err
+	}
+	report := newDeactivationReport(mode)
+	known := make(map[string]bool)
+	rows, err := tx.Query(`SELECT user_id, is_active FROM users WHERE `+s.d.inArray("user_id", "$1")+`
+        ORDER BY user_id`+s.d.forUpdate(), s.d.array(ids))
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			return err
+		return nil, err
+	}
+	for rows.Next() {
+		var id string
+		var active bool
+		if err := rows.Scan(&id, &active); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan user: %w", err)
 		}
-		return err
+		known[id] = active
 	}
-	rows, err := tx.Query(`
-        SELECT id, author_id
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		active, ok := known[id]
+		switch {
+		case !ok:
+			if !contains(report.Unknown, id) {
+				report.Unknown = append(report.Unknown, id)
+			}
+		case active:
+			if !contains(report.Deactivated, id) {
+				report.Deactivated = append(report.Deactivated, id)
+			}
+		default:
+			if !contains(report.AlreadyInactive, id) {
+				report.AlreadyInactive = append(report.AlreadyInactive, id)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE users SET is_active = false WHERE `+s.d.inArray("user_id", "$1"), s.d.array(ids))
+	if err != nil {
+		return nil, err
+	}
+	rows, err = tx.Query(`
+        SELECT pr_id, author_id, assigned_reviewers, assignment, reviews
         FROM pull_requests
-        WHERE reviewer_id = ANY($1) AND status = 'OPEN'
-    `, pq.Array(ids))
+        WHERE status = 'OPEN' AND `+s.d.overlaps("assigned_reviewers", "$1")+`
+        ORDER BY created_at, pr_id`+s.d.forUpdate(), s.d.array(ids))
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			return err
-		}
-		return err
+		return nil, err
 	}
 
-	prs := []struct{ id, author string }{}
+	var prs []PullRequest
 	for rows.Next() {
-		var id, author string
-		if err := rows.Scan(&id, &author); err != nil {
-			return fmt.Errorf("scan pr data: %w", err)
+		var pr PullRequest
+		if err := rows.Scan(&pr.PullRequestID, &pr.AuthorID, s.d.scanArray(&pr.AssignedReviewers),
+			jsonColumn{dst: &pr.Assignment}, jsonColumn{dst: &pr.Reviews}); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan pr data: %w", err)
 		}
-		prs = append(prs, struct{ id, author string }{id, author})
+		prs = append(prs, pr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
+	if err := s.lockPools(tx, prAuthors(prs)); err != nil {
+		return nil, err
+	}
+	load, err := s.openLoad(tx)
+	if err != nil {
+		return nil, err
+	}
 	for _, pr := range prs {
-		reviewer, err := selectReviewer(tx, pr.author)
+		authorID := pr.AuthorID
+		affected, err := restaff(s.Assigner, &pr, ids, "deactivated", mode, load, func(exclude []string) (*reviewPool, error) {
+			return s.reviewPool(tx, authorID, exclude, s.d.forUpdate())
+		}, func(p *reviewPool) error {
+			return s.saveCursor(tx, p)
+		})
 		if err != nil {
-			if err := tx.Rollback(); err != nil {
-				return err
-			}
-			return errors.New("NO_REVIEWERS")
+			return nil, err
 		}
 
 		_, err = tx.Exec(`
-            UPDATE pull_requests SET reviewer_id = $1 WHERE id = $2
-        `, reviewer, pr.id)
+            UPDATE pull_requests SET assigned_reviewers = $1, assignment = $2, reviews = $3 WHERE pr_id = $4
+        `, s.d.array(pr.AssignedReviewers), jsonColumn{v: pr.Assignment}, jsonColumn{v: pr.Reviews}, pr.PullRequestID)
 		if err != nil {
-			if err := tx.Rollback(); err != nil {
-				return err
-			}
-			return err
+			return nil, err
 		}
+		report.PullRequests = append(report.PullRequests, affected)
 	}
 
-	return tx.Commit()
-}
-
-func GetStats() (*Stats, error) {
-	stats := &Stats{
-		AssignmentsByUser: make(map[string]int),
-		AssignmentsByPR:   make(map[string]int),
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
+	return report, nil
+}
 
-	rows, err := Db.Query(`
-        SELECT reviewer_id, COUNT(*) 
-        FROM pull_requests 
-        GROUP BY reviewer_id
-    `)
+func (s *SQLStore) GetStats(filter StatsFilter) (*Stats, error) {
+	teamOf := make(map[string]string)
+	rows, err := s.Db.Query(`SELECT user_id, COALESCE(team_name, '') FROM users`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
 	for rows.Next() {
-		var user string
-		var count int
-		if err := rows.Scan(&user, &count); err != nil {
-			return nil, fmt.Errorf("scan user stats: %w", err)
+		var user, team string
+		if err := rows.Scan(&user, &team); err != nil {
+			return nil, fmt.Errorf("scan user team: %w", err)
 		}
-		stats.AssignmentsByUser[user] = count
+		teamOf[user] = team
 	}
-	rows2, err := Db.Query(`
-        SELECT id, 1 
-        FROM pull_requests
-    `)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The time range is checked in Go: SQLite keeps timestamps as text and
+	// can't compare them reliably across time zones.
+	query := `SELECT p.pr_id, p.author_id, p.status, p.assigned_reviewers, p.created_at
+        FROM pull_requests p
+        JOIN users u ON u.user_id = p.author_id`
+	var args []interface{}
+	if filter.Team != "" {
+		query += ` WHERE u.team_name = $1`
+		args = append(args, filter.Team)
+	}
+	rows2, err := s.Db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows2.Close()
 
+	var prs []PullRequest
 	for rows2.Next() {
-		var pr string
-		var one int
-		if err := rows2.Scan(&pr, &one); err != nil {
+		var pr PullRequest
+		if err := rows2.Scan(&pr.PullRequestID, &pr.AuthorID, &pr.Status,
+			s.d.scanArray(&pr.AssignedReviewers), &pr.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scan pr stats: %w", err)
 		}
-		stats.AssignmentsByPR[pr] = one
+		if filter.match(pr, teamOf[pr.AuthorID]) {
+			prs = append(prs, pr)
+		}
+	}
+	if err := rows2.Err(); err != nil {
+		return nil, err
+	}
+
+	rows3, err := s.Db.Query(`SELECT pr_id, user_id, replaced_by, reason, created_at FROM review_declines ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows3.Close()
+	var declines []Decline
+	for rows3.Next() {
+		var d Decline
+		if err := rows3.Scan(&d.PullRequestID, &d.UserID, &d.ReplacedBy, &d.Reason, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan decline: %w", err)
+		}
+		declines = append(declines, d)
+	}
+	if err := rows3.Err(); err != nil {
+		return nil, err
 	}
 
-	return stats, nil
+	return buildStats(prs, teamOf, declines), nil
 }
 
-func GetPR(prID string) (*PullRequest, error) {
-	var pr PullRequest
-	err := Db.QueryRow(`SELECT pr_id,
-        pr_name,
-        author_id,
-        status,
-		assigned_reviewers,
-		created_at,
-		merged_at
-        FROM pull_requests where pr_id = $1`, prID).Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status,
-		pq.Array(&pr.AssignedReviewers), &pr.CreatedAt, &pr.MergedAt)
+func (s *SQLStore) GetPR(prID string) (*PullRequest, error) {
+	pr, err := s.scanPR(s.Db.QueryRow(`SELECT `+prColumns+` FROM pull_requests where pr_id = $1`, prID))
 
+	if err == sql.ErrNoRows {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &pr, nil
 }
 
-func GetReview(userID string) ([]PullRequest, error) {
-	rows, err := Db.Query(`SELECT pr_id,
-        pr_name,
-        author_id,
-        status,
-		assigned_reviewers,
-		created_at,
-		merged_at
+func (s *SQLStore) GetReview(userID string) ([]PullRequest, error) {
+	rows, err := s.Db.Query(`SELECT `+prColumns+`
         FROM pull_requests
-        WHERE $1 = ANY(assigned_reviewers)`, userID)
+        WHERE `+s.d.inArray("$1", "assigned_reviewers"), userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	result := []PullRequest{}
 	for rows.Next() {
-		var pr PullRequest
-		err := rows.Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status,
-			pq.Array(&pr.AssignedReviewers), &pr.CreatedAt, &pr.MergedAt)
+		pr, err := s.scanPR(rows)
 		if err != nil {
 			return nil, err
 
@@ -293,247 +525,811 @@ func GetReview(userID string) ([]PullRequest, error) {
 	}
 
 	if len(result) == 0 {
-		return nil, errors.New("NOT_FOUND")
+		return nil, errs.ErrNotFound.WithMessage("user has no assigned pull requests").WithIDs(userID)
 	}
 
 	return result, nil
 
 }
 
-func ChangeReviewer(prID, oldReviewerID string) (*PullRequest, string, error) {
-	var pr PullRequest
-	err := Db.QueryRow(`select pr_id,
-    pr_name,
-    author_id,
-    status,
-    assigned_reviewers,
-    created_at,
-    merged_at FROM pull_requests WHERE pr_id = $1`, prID).Scan(&pr.PullRequestID,
-		&pr.PullRequestName, &pr.AuthorID, &pr.Status, &pr.AssignedReviewers, &pr.CreatedAt, &pr.MergedAt)
-	if err == sql.ErrNoRows {
-		return nil, "", errors.New("NOT_FOUND")
+func (s *SQLStore) ChangeReviewer(prID, oldReviewerID, newReviewerID string) (*Reassignment, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := s.changeReviewer(tx, prID, oldReviewerID, newReviewerID)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	if pr.Status == "MERGED" {
-		return nil, "", errors.New("MERGED_LOCKED")
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	if !contains(pr.AssignedReviewers, oldReviewerID) {
-		return nil, "", errors.New("NOT_ASSIGNED")
+	return res, nil
+}
+
+func (s *SQLStore) DeclineReview(prID, reviewerID, reason string) (*Reassignment, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
 	}
-	var teamPrName string
+	defer func() { _ = tx.Rollback() }()
 
-	err = Db.QueryRow(`select team_name from users where user_id = $1`, pr.AuthorID).Scan(&teamPrName)
+	res, err := s.changeReviewer(tx, prID, reviewerID, "")
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	var newID string
-	err = Db.QueryRow(`
-		select user_id
-		from users 
-		WHERE team_name = $1 and is_active = true and user_id != $2 and user_id != $3 ORDER BY RANDOM() limit 1 `,
-		teamPrName, pr.AuthorID, oldReviewerID).Scan(&newID)
-	if err == sql.ErrNoRows {
-		return nil, "", errors.New("NO_REPLACEMENT_FOUND")
+	res.Decline = &Decline{
+		PullRequestID: prID,
+		UserID:        reviewerID,
+		ReplacedBy:    res.NewReviewer.UserID,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
 	}
+	_, err = tx.Exec(`
+        INSERT INTO review_declines (pr_id, user_id, replaced_by, reason, created_at)
+        VALUES ($1, $2, $3, $4, $5)`,
+		prID, reviewerID, res.Decline.ReplacedBy, reason, res.Decline.CreatedAt)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	for k, v := range pr.AssignedReviewers {
-		if v == oldReviewerID {
-			pr.AssignedReviewers[k] = newID
-		}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
-	_, err = Db.Exec(`
-        UPDATE pull_requests
-        SET assigned_reviewers = $1
-        WHERE pr_id = $2
-    `, pq.Array(pr.AssignedReviewers), prID)
+	return res, nil
+}
 
+func (s *SQLStore) HandOverReviews(userID, successorID string) (*HandoverReport, error) {
+	tx, err := s.Db.Begin()
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
-	return &pr, fmt.Sprintf("replaced by %s", newID), nil
-
-}
+	defer func() { _ = tx.Rollback() }()
 
-func StatusMerged(prID string) (*PullRequest, error) {
-	var pr PullRequest
-	var reviewersBytes []byte
-	err := Db.QueryRow(`SELECT pr_id, pr_name, author_id, status, assigned_reviewers, created_at, merged_at
-	                    FROM pull_requests
-	                    WHERE pr_id = $1`, prID).
-		Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status, &reviewersBytes, &pr.CreatedAt, &pr.MergedAt)
-	if err == sql.ErrNoRows {
-		return nil, errors.New("NOT_FOUND")
+	for _, id := range []string{userID, successorID} {
+		if id == "" {
+			continue
+		}
+		if _, err := s.reviewer(tx, id); err != nil {
+			return nil, err
+		}
 	}
+	rows, err := tx.Query(`
+        SELECT pr_id FROM pull_requests
+        WHERE status = 'OPEN' AND `+s.d.overlaps("assigned_reviewers", "$1")+`
+        ORDER BY created_at, pr_id`, s.d.array([]string{userID}))
 	if err != nil {
 		return nil, err
 	}
-	if len(reviewersBytes) > 0 {
-		if err := json.Unmarshal(reviewersBytes, &pr.AssignedReviewers); err != nil {
-			pr.AssignedReviewers = []string{}
+	var prIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan pr id: %w", err)
 		}
-	} else {
-		pr.AssignedReviewers = []string{}
+		prIDs = append(prIDs, id)
 	}
-	if pr.Status == "MERGED" {
-		return nil, errors.New("ALREADY_MERGED")
-	}
-	pr.Status = "MERGED"
-	now := time.Now()
-	_, err = Db.Exec(`UPDATE pull_requests SET status = $1, merged_at = $2 WHERE pr_id = $3`,
-		pr.Status, now, prID)
-	if err != nil {
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	pr.MergedAt = &now
-	return &pr, nil
-}
-
-func CreatePR(pr *PullRequest) (*PullRequest, error) {
-	var exists string
-	err := Db.QueryRow(`SELECT pr_id FROM pull_requests WHERE pr_id = $1`, pr.PullRequestID).Scan(&exists)
-
-	if err == nil {
-
-		return nil, errors.New("PR_EXISTS")
+	report := &HandoverReport{UserID: userID, SuccessorID: successorID, PullRequests: []HandedOverPR{}}
+	change := func(prID, oldReviewerID, newReviewerID string) (*Reassignment, error) {
+		return s.changeReviewer(tx, prID, oldReviewerID, newReviewerID)
 	}
-	if err != sql.ErrNoRows {
+	for _, prID := range prIDs {
+		handed, err := handOver(change, prID, userID, successorID)
+		if err != nil {
+			return nil, err
+		}
+		report.PullRequests = append(report.PullRequests, handed)
+	}
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
-	var teamPrName string
+	return report, nil
+}
 
-	err = Db.QueryRow(`select team_name from users where user_id = $1`, pr.AuthorID).Scan(&teamPrName)
+// changeReviewer does ChangeReviewer inside tx.
+func (s *SQLStore) changeReviewer(tx *sql.Tx, prID, oldReviewerID, newReviewerID string) (*Reassignment, error) {
+	pr, err := s.scanPR(tx.QueryRow(`SELECT `+prColumns+` FROM pull_requests WHERE pr_id = $1`+s.d.forUpdate(), prID))
 	if err == sql.ErrNoRows {
-		return nil, errors.New("AUTHOR_NOT_FOUND")
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
 	}
 	if err != nil {
 		return nil, err
 	}
-	rows, err := Db.Query(`
-		SELECT user_id
-		FROM users 
-		WHERE team_name = $1 AND is_active = true and user_id != $2`, teamPrName, pr.AuthorID)
+	if pr.Status == StatusMerged {
+		return nil, errs.ErrPRMerged.WithIDs(prID)
+	}
+	if pr.Status != StatusOpen {
+		return nil, errs.ErrNotOpen.WithIDs(prID)
+	}
+	if !contains(pr.AssignedReviewers, oldReviewerID) {
+		return nil, errs.ErrNotAssigned.WithIDs(prID, oldReviewerID)
+	}
+	pool, err := s.reviewPool(tx, pr.AuthorID, pr.AssignedReviewers, s.d.forUpdate())
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var members []string
-	for rows.Next() {
-		var uid string
-		if err := rows.Scan(&uid); err != nil {
-			return nil, fmt.Errorf("scan uid: %w", err)
+	load, err := s.openLoad(tx)
+	if err != nil {
+		return nil, err
+	}
+	var picked Pick
+	var dr Draw
+	if newReviewerID != "" {
+		if picked, err = pool.choose(newReviewerID, load); err != nil {
+			return nil, err
+		}
+	} else {
+		var picks []Pick
+		picks, dr = s.Assigner.pick(pool, load, 1)
+		if len(picks) == 0 {
+			return nil, errs.ErrNoReplacement.WithIDs(prID, oldReviewerID)
 		}
-		members = append(members, uid)
+		picked = picks[0]
 	}
-	rand.Shuffle(len(members), func(i, j int) {
-		members[i], members[j] = members[j], members[i]
-	})
+	if err := s.saveCursor(tx, pool); err != nil {
+		return nil, err
+	}
+	newID := reassign(&pr, oldReviewerID, picked, dr)
+	_, err = tx.Exec(`
+        UPDATE pull_requests
+        SET assigned_reviewers = $1, assignment = $2, reviews = $3
+        WHERE pr_id = $4
+    `, s.d.array(pr.AssignedReviewers), jsonColumn{v: pr.Assignment}, jsonColumn{v: pr.Reviews}, prID)
 
-	n := rand.Intn(2) + 1
-	if n > len(members) {
-		n = len(members)
+	if err != nil {
+		return nil, err
+	}
+	res := &Reassignment{PullRequest: &pr}
+	if res.OldReviewer, err = s.reviewer(tx, oldReviewerID); err != nil {
+		return nil, err
+	}
+	if res.NewReviewer, err = s.reviewer(tx, newID); err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
-	selected := members[:n]
-	pr.CreatedAt = time.Now()
-	pr.Status = "OPEN"
-	pr.AssignedReviewers = selected
-	_, err = Db.Exec(`
-		INSERT INTO pull_requests (
-			pr_id, pr_name, author_id, status,
-			assigned_reviewers, created_at, merged_at
-		) VALUES ($1, $2, $3, $4, $5, $6, NULL)
-	`, pr.PullRequestID, pr.PullRequestName, pr.AuthorID,
-		pr.Status, pq.Array(selected), pr.CreatedAt)
+func (s *SQLStore) reviewer(q querier, userID string) (Reviewer, error) {
+	r := Reviewer{UserID: userID}
+	err := q.QueryRow(`SELECT username, COALESCE(team_name, '') FROM users WHERE user_id = $1`, userID).
+		Scan(&r.Username, &r.TeamName)
+	if err == sql.ErrNoRows {
+		return r, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	return r, err
+}
 
+func (s *SQLStore) MergeOverrides(prID string) ([]MergeOverride, error) {
+	query := `SELECT pr_id, actor, reason, missing, created_at FROM merge_overrides`
+	var args []interface{}
+	if prID != "" {
+		query += ` WHERE pr_id = $1`
+		args = append(args, prID)
+	}
+	rows, err := s.Db.Query(query+` ORDER BY id`, args...)
 	if err != nil {
 		return nil, err
 	}
-
-	return pr, nil
+	defer rows.Close()
+	res := []MergeOverride{}
+	for rows.Next() {
+		var o MergeOverride
+		if err := rows.Scan(&o.PullRequestID, &o.Actor, &o.Reason, s.d.scanArray(&o.Missing), &o.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan merge override: %w", err)
+		}
+		if o.Missing == nil {
+			o.Missing = []string{}
+		}
+		res = append(res, o)
+	}
+	return res, rows.Err()
 }
 
-func SetIsActive(userID string, isActive bool) (*User, error) {
-	var user User
-	err := Db.QueryRow(
-		`SELECT user_id, username, team_name, is_active 
-		 FROM users WHERE user_id = $1`, userID).
-		Scan(&user.UserID, &user.Username, &user.TeamName, &user.IsActive)
+func (s *SQLStore) SubmitReview(prID, reviewerID, verdict string) (*PullRequest, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
 
+	pr, err := s.scanPR(tx.QueryRow(`SELECT `+prColumns+` FROM pull_requests WHERE pr_id = $1`+s.d.forUpdate(), prID))
 	if err == sql.ErrNoRows {
-		return nil, errors.New("NOT_FOUND")
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
 	}
 	if err != nil {
 		return nil, err
 	}
-	line := "update users set is_active = $1 where user_id = $2"
-	_, err = Db.Exec(line, isActive, userID)
+	leads, err := s.teamLeads(tx, pr.AuthorID)
 	if err != nil {
 		return nil, err
 	}
-	user.IsActive = isActive
-	return &user, nil
-
-}
-
-func TeamAdd(teamname string, members []TeamMember) (*Team, error) {
-	var exists string
-	err := Db.QueryRow(`SELECT team_name FROM teams WHERE team_name = $1`, teamname).Scan(&exists)
-
-	if err == nil {
-
-		return nil, errors.New("TEAM_EXISTS")
+	if err := review(&pr, reviewerID, verdict, contains(leads, reviewerID), time.Now()); err != nil {
+		return nil, err
 	}
-
-	if err != sql.ErrNoRows {
+	if _, err := tx.Exec(`UPDATE pull_requests SET reviews = $1 WHERE pr_id = $2`, jsonColumn{v: pr.Reviews}, prID); err != nil {
 		return nil, err
 	}
-
-	line := `insert into teams (team_name) values ($1)`
-	_, err = Db.Exec(line, teamname)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
-	for _, value := range members {
-		_, err := Db.Exec(`insert into users (user_id, username, team_name, is_active) values($1,$2,$3,$4) on conflict (user_id) do update
-            set username = excluded.username,
-                team_name = excluded.team_name,
-                is_active = excluded.is_active`,
-			value.UserID, value.Username, teamname, value.IsActive)
+	return &pr, nil
+}
+
+func (s *SQLStore) StatusMerged(prID string, override *MergeOverride) (*PullRequest, error) {
+	return s.transition(prID, ActionMerge, override)
+}
+
+func (s *SQLStore) Transition(prID string, action PRAction) (*PullRequest, error) {
+	return s.transition(prID, action, nil)
+}
+
+// teamLeads returns the leads of the author's team.
+func (s *SQLStore) teamLeads(q querier, authorID string) ([]string, error) {
+	rows, err := q.Query(`
+        SELECT tm.user_id FROM team_members tm
+        JOIN users u ON u.team_name = tm.team_name
+        WHERE u.user_id = $1 AND tm.is_lead = true`, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	leads := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan lead: %w", err)
+		}
+		leads = append(leads, id)
+	}
+	return leads, rows.Err()
+}
+
+func (s *SQLStore) transition(prID string, action PRAction, override *MergeOverride) (*PullRequest, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	pr, err := s.scanPR(tx.QueryRow(`SELECT `+prColumns+` FROM pull_requests WHERE pr_id = $1`+s.d.forUpdate(), prID))
+	if err == sql.ErrNoRows {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if action == ActionMerge {
+		leads, err := s.teamLeads(tx, pr.AuthorID)
 		if err != nil {
 			return nil, err
 		}
-		_, err = Db.Exec(`
-            insert into team_members (team_name, user_id)
-            values ($1, $2)
-            on conflict do nothing
-        `, teamname, value.UserID)
-
+		audit, err := gateMerge(s.Policy, &pr, leads, override, now)
+		if err != nil {
+			return nil, err
+		}
+		if audit != nil {
+			_, err = tx.Exec(`
+                INSERT INTO merge_overrides (pr_id, actor, reason, missing, created_at)
+                VALUES ($1, $2, $3, $4, $5)`,
+				audit.PullRequestID, audit.Actor, audit.Reason, s.d.array(audit.Missing), audit.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	needsReviewers, err := transition(&pr, action, now)
+	if err != nil {
+		return nil, err
+	}
+	if needsReviewers {
+		pool, err := s.reviewPool(tx, pr.AuthorID, nil, s.d.forUpdate())
+		if err != nil {
+			return nil, err
+		}
+		load, err := s.openLoad(tx)
 		if err != nil {
 			return nil, err
 		}
+		if err := staff(s.Assigner, &pr, pool, load, false); err != nil {
+			return nil, err
+		}
+		if err := s.saveCursor(tx, pool); err != nil {
+			return nil, err
+		}
+	} else if action == ActionReopen {
+		load, err := s.openLoad(tx)
+		if err != nil {
+			return nil, err
+		}
+		err = refreshReviewers(s.Assigner, &pr, load, func(exclude []string) (*reviewPool, error) {
+			return s.reviewPool(tx, pr.AuthorID, exclude, s.d.forUpdate())
+		}, func(p *reviewPool) error {
+			return s.saveCursor(tx, p)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec(`
+        UPDATE pull_requests
+        SET status = $1, merged_at = $2, closed_at = $3, assigned_reviewers = $4,
+            required_reviewers = $5, assignment = $6, reviews = $7
+        WHERE pr_id = $8`,
+		pr.Status, pr.MergedAt, pr.ClosedAt, s.d.array(pr.AssignedReviewers),
+		pr.RequiredReviewers, jsonColumn{v: pr.Assignment}, jsonColumn{v: pr.Reviews}, prID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// CreatePR runs in one transaction: reviewPool locks the author's team,
+// author included, so a concurrent SetIsActive can't slip in between choosing
+// and inserting, and a duplicate pr_id racing past the existence check is
+// caught by the primary key and reported as PR_EXISTS.
+func (s *SQLStore) CreatePR(pr *PullRequest, strict bool) (*PullRequest, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists string
+	err = tx.QueryRow(`SELECT pr_id FROM pull_requests WHERE pr_id = $1`, pr.PullRequestID).Scan(&exists)
+
+	if err == nil {
+
+		return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	pool, err := s.reviewPool(tx, pr.AuthorID, nil, s.d.forUpdate())
+	if err != nil {
+		return nil, err
+	}
+	load, err := s.openLoad(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := newPR(s.Assigner, pr, pool, load, strict); err != nil {
+		return nil, err
+	}
+	if err := s.saveCursor(tx, pool); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO pull_requests (
+			pr_id, pr_name, author_id, status,
+			assigned_reviewers, created_at, merged_at, required_reviewers, assignment
+		) VALUES ($1, $2, $3, $4, $5, $6, NULL, $7, $8)
+	`, pr.PullRequestID, pr.PullRequestName, pr.AuthorID,
+		pr.Status, s.d.array(pr.AssignedReviewers), pr.CreatedAt, pr.RequiredReviewers, jsonColumn{v: pr.Assignment})
+
+	if s.d.isUniqueViolation(err) {
+		return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		if s.d.isUniqueViolation(err) {
+			return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+		}
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// PreviewPR runs the assignment of CreatePR without saving anything, neither
+// the pull request nor the rotation cursor. It reads outside a transaction
+// and locks nothing, so a preview never holds up creates and reassignments.
+func (s *SQLStore) PreviewPR(pr PullRequest, strict bool) (*AssignmentPreview, error) {
+	var exists string
+	err := s.Db.QueryRow(`SELECT pr_id FROM pull_requests WHERE pr_id = $1`, pr.PullRequestID).Scan(&exists)
+	if err == nil {
+		return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	pool, err := s.reviewPool(s.Db, pr.AuthorID, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	load, err := s.openLoad(s.Db)
+	if err != nil {
+		return nil, err
+	}
+	return preview(s.Assigner, pr, pool, load, strict)
+}
+
+func (s *SQLStore) SetIsActive(userID string, isActive bool) (*User, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	user, err := s.getUser(tx, userID, s.d.forUpdate())
+	if err != nil {
+		return nil, err
+	}
+	line := "update users set is_active = $1 where user_id = $2"
+	_, err = tx.Exec(line, isActive, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	user.IsActive = isActive
+	return user, nil
+
+}
+
+// getUser loads a user with their current OPEN review load; lock is appended
+// to the user query.
+func (s *SQLStore) getUser(q querier, userID, lock string) (*User, error) {
+	var user User
+	err := q.QueryRow(
+		`SELECT user_id, username, COALESCE(team_name, ''), is_active, review_weight, COALESCE(max_open_reviews, 0)
+		 FROM users WHERE user_id = $1`+lock, userID).
+		Scan(&user.UserID, &user.Username, &user.TeamName, &user.IsActive, &user.ReviewWeight, &user.MaxOpenReviews)
+	if err == sql.ErrNoRows {
+		return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	load, err := s.openLoad(q)
+	if err != nil {
+		return nil, err
+	}
+	user.OpenReviews = load[userID]
+	return &user, nil
+}
+
+func (s *SQLStore) GetUser(userID string) (*User, error) {
+	return s.getUser(s.Db, userID, "")
+}
+
+func (s *SQLStore) SetCapacity(userID string, maxOpenReviews int) (*User, error) {
+	res, err := s.Db.Exec(`UPDATE users SET max_open_reviews = NULLIF($1, 0) WHERE user_id = $2`, maxOpenReviews, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	return s.GetUser(userID)
+}
+
+func (s *SQLStore) TeamAdd(team Team) (*Team, error) {
+	var exists string
+	err := s.Db.QueryRow(`SELECT team_name FROM teams WHERE team_name = $1`, team.TeamName).Scan(&exists)
+
+	if err == nil {
+
+		return nil, errs.ErrTeamExists.WithIDs(team.TeamName)
+	}
+
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	line := `insert into teams (team_name, reviewer_strategy, required_reviewers) values ($1, NULLIF($2, ''), NULLIF($3, 0))`
+	_, err = s.Db.Exec(line, team.TeamName, team.ReviewerStrategy, team.RequiredReviewers)
+	if err != nil {
+		return nil, err
+	}
+	for k, value := range team.Members {
+		if value, err = s.upsertMember(s.Db, team.TeamName, value); err != nil {
+			return nil, err
+		}
+		team.Members[k] = value
 
 	}
 
-	return &Team{
-		TeamName: teamname,
-		Members:  members,
-	}, nil
+	return &team, nil
 
 }
-func GetTeam(teamname string) (Team, error) {
+
+// upsertMember creates or updates a user and makes them a member of
+// teamName only.
+func (s *SQLStore) upsertMember(q querier, teamName string, value TeamMember) (TeamMember, error) {
+	if value.ReviewWeight <= 0 {
+		value.ReviewWeight = 1
+	}
+	_, err := q.Exec(`insert into users (user_id, username, team_name, is_active, review_weight, max_open_reviews)
+        values($1,$2,$3,$4,$5,NULLIF($6, 0)) on conflict (user_id) do update
+        set username = excluded.username,
+            team_name = excluded.team_name,
+            is_active = excluded.is_active,
+            review_weight = excluded.review_weight,
+            max_open_reviews = excluded.max_open_reviews`,
+		value.UserID, value.Username, teamName, value.IsActive, value.ReviewWeight, value.MaxOpenReviews)
+	if err != nil {
+		return value, err
+	}
+	_, err = q.Exec(`delete from team_members where user_id = $1 and team_name <> $2`, value.UserID, teamName)
+	if err != nil {
+		return value, err
+	}
+	_, err = q.Exec(`
+        insert into team_members (team_name, user_id, is_lead)
+        values ($1, $2, $3)
+        on conflict (team_name, user_id) do update set is_lead = excluded.is_lead
+    `, teamName, value.UserID, value.IsLead)
+	return value, err
+}
+
+// lockTeam fails with NOT_FOUND unless teamName exists and locks its row.
+func (s *SQLStore) lockTeam(tx *sql.Tx, teamName string) error {
+	var name string
+	err := tx.QueryRow(`SELECT team_name FROM teams WHERE team_name = $1`+s.d.forUpdate(), teamName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return errs.ErrNotFound.WithMessage("team not found").WithIDs(teamName)
+	}
+	return err
+}
+
+// teamMembers returns the ids of the users in teamName.
+func (s *SQLStore) teamMembers(q querier, teamName string) ([]string, error) {
+	rows, err := q.Query(`SELECT user_id FROM users WHERE team_name = $1 ORDER BY user_id`, teamName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan member: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLStore) ListTeams(limit, offset int) (*TeamPage, error) {
+	page := &TeamPage{Teams: []TeamSummary{}, Limit: limit, Offset: offset}
+	if err := s.Db.QueryRow(`SELECT COUNT(*) FROM teams`).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+	rows, err := s.Db.Query(`
+        SELECT t.team_name, COALESCE(t.reviewer_strategy, ''), COALESCE(t.required_reviewers, 0),
+            (SELECT COUNT(*) FROM users u WHERE u.team_name = t.team_name)
+        FROM teams t
+        ORDER BY t.team_name
+        LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TeamSummary
+		if err := rows.Scan(&t.TeamName, &t.ReviewerStrategy, &t.RequiredReviewers, &t.MemberCount); err != nil {
+			return nil, fmt.Errorf("scan team: %w", err)
+		}
+		page.Teams = append(page.Teams, t)
+	}
+	return page, rows.Err()
+}
+
+func (s *SQLStore) AddMembers(teamName string, members []TeamMember) (Team, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return Team{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockTeam(tx, teamName); err != nil {
+		return Team{}, err
+	}
+	for _, value := range members {
+		if _, err := s.upsertMember(tx, teamName, value); err != nil {
+			return Team{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Team{}, err
+	}
+	return s.GetTeam(teamName)
+}
+
+func (s *SQLStore) RemoveMembers(teamName string, userIDs []string) (Team, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return Team{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockTeam(tx, teamName); err != nil {
+		return Team{}, err
+	}
+	members, err := s.teamMembers(tx, teamName)
+	if err != nil {
+		return Team{}, err
+	}
+	if missing := notIn(userIDs, members); len(missing) > 0 {
+		return Team{}, errs.ErrNotFound.WithMessage("users are not members of the team").WithIDs(missing...)
+	}
+	_, err = tx.Exec(`DELETE FROM team_members WHERE team_name = $1 AND `+s.d.inArray("user_id", "$2"),
+		teamName, s.d.array(userIDs))
+	if err != nil {
+		return Team{}, err
+	}
+	_, err = tx.Exec(`UPDATE users SET team_name = NULL WHERE team_name = $1 AND `+s.d.inArray("user_id", "$2"),
+		teamName, s.d.array(userIDs))
+	if err != nil {
+		return Team{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Team{}, err
+	}
+	return s.GetTeam(teamName)
+}
+
+func (s *SQLStore) RenameTeam(oldName, newName string) (Team, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return Team{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockTeam(tx, oldName); err != nil {
+		return Team{}, err
+	}
+	var exists string
+	err = tx.QueryRow(`SELECT team_name FROM teams WHERE team_name = $1`, newName).Scan(&exists)
+	if err == nil {
+		return Team{}, errs.ErrTeamExists.WithIDs(newName)
+	}
+	if err != sql.ErrNoRows {
+		return Team{}, err
+	}
+	// team_members references teams, so the row is copied under the new name
+	// instead of updating its key.
+	for _, q := range []string{
+		`INSERT INTO teams (team_name, reviewer_strategy, required_reviewers, rotation_cursor)
+            SELECT $2, reviewer_strategy, required_reviewers, rotation_cursor FROM teams WHERE team_name = $1`,
+		`UPDATE team_members SET team_name = $2 WHERE team_name = $1`,
+		`UPDATE users SET team_name = $2 WHERE team_name = $1`,
+		`DELETE FROM teams WHERE team_name = $1`,
+	} {
+		if _, err := tx.Exec(q, oldName, newName); err != nil {
+			return Team{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Team{}, err
+	}
+	return s.GetTeam(newName)
+}
+
+func (s *SQLStore) DeleteTeam(teamName string, policy TeamDeletePolicy) (*TeamDeletionReport, error) {
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockTeam(tx, teamName); err != nil {
+		return nil, err
+	}
+	report := newTeamDeletionReport(teamName, policy)
+	if report.Members, err = s.teamMembers(tx, teamName); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`
+        SELECT `+prColumns+` FROM pull_requests
+        WHERE status IN ('DRAFT', 'OPEN')
+            AND (`+s.d.inArray("author_id", "$1")+` OR `+s.d.overlaps("assigned_reviewers", "$1")+`)
+        ORDER BY created_at, pr_id`+s.d.forUpdate(), s.d.array(report.Members))
+	if err != nil {
+		return nil, err
+	}
+	var prs []PullRequest
+	for rows.Next() {
+		pr, err := s.scanPR(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan pr data: %w", err)
+		}
+		if inProgress(pr, report.Members) {
+			prs = append(prs, pr)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(prs) > 0 && policy != TeamDeleteClose {
+		return nil, teamInUse(teamName, prs)
+	}
+
+	if err := s.lockPools(tx, prAuthors(prs)); err != nil {
+		return nil, err
+	}
+	load, err := s.openLoad(tx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, pr := range prs {
+		if contains(report.Members, pr.AuthorID) {
+			if _, err := transition(&pr, ActionClose, now); err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(`UPDATE pull_requests SET status = $1, closed_at = $2 WHERE pr_id = $3`,
+				pr.Status, pr.ClosedAt, pr.PullRequestID)
+			if err != nil {
+				return nil, err
+			}
+			report.ClosedPRs = append(report.ClosedPRs, pr.PullRequestID)
+			continue
+		}
+		authorID := pr.AuthorID
+		affected, err := restaff(s.Assigner, &pr, report.Members, "removed", DeactivateLenient, load,
+			func(exclude []string) (*reviewPool, error) {
+				return s.reviewPool(tx, authorID, exclude, s.d.forUpdate())
+			}, func(p *reviewPool) error {
+				return s.saveCursor(tx, p)
+			})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+            UPDATE pull_requests SET assigned_reviewers = $1, assignment = $2, reviews = $3 WHERE pr_id = $4
+        `, s.d.array(pr.AssignedReviewers), jsonColumn{v: pr.Assignment}, jsonColumn{v: pr.Reviews}, pr.PullRequestID)
+		if err != nil {
+			return nil, err
+		}
+		report.PullRequests = append(report.PullRequests, affected)
+	}
+
+	for _, q := range []string{
+		`UPDATE users SET team_name = NULL WHERE team_name = $1`,
+		`DELETE FROM team_members WHERE team_name = $1`,
+		`DELETE FROM teams WHERE team_name = $1`,
+	} {
+		if _, err := tx.Exec(q, teamName); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+func (s *SQLStore) GetTeam(teamname string) (Team, error) {
 	team := Team{}
-	err := Db.QueryRow(`select team_name from teams where team_name = $1`, teamname).Scan(&team.TeamName)
+	err := s.Db.QueryRow(`select team_name, COALESCE(reviewer_strategy, ''), COALESCE(required_reviewers, 0)
+        from teams where team_name = $1`, teamname).
+		Scan(&team.TeamName, &team.ReviewerStrategy, &team.RequiredReviewers)
+	if err == sql.ErrNoRows {
+		return Team{}, errs.ErrNotFound.WithMessage("team not found").WithIDs(teamname)
+	}
 	if err != nil {
 		return Team{}, err
 	}
-	rows, err := Db.Query(`
-        SELECT u.user_id, u.username, u.is_active FROM users u
+	rows, err := s.Db.Query(`
+        SELECT u.user_id, u.username, u.is_active, u.review_weight, COALESCE(u.max_open_reviews, 0), tm.is_lead FROM users u
         JOIN team_members tm ON tm.user_id = u.user_id
         WHERE tm.team_name = $1
     `, teamname)
@@ -546,61 +1342,23 @@ func GetTeam(teamname string) (Team, error) {
 
 	for rows.Next() {
 		var m TeamMember
-		if err := rows.Scan(&m.UserID, &m.Username, &m.IsActive); err != nil {
+		if err := rows.Scan(&m.UserID, &m.Username, &m.IsActive, &m.ReviewWeight, &m.MaxOpenReviews, &m.IsLead); err != nil {
 			return Team{}, err
 		}
 		members = append(members, m)
 	}
-
-	team.Members = members
-	return team, nil
-
-}
-
-func CreateTables() error {
-	_, err := Db.Exec(`CREATE TABLE IF NOT EXISTS users (
-    user_id TEXT PRIMARY KEY,
-    username TEXT NOT NULL,
-    team_name TEXT,
-    is_active BOOLEAN NOT NULL
-);
-	`)
-	if err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return Team{}, err
 	}
-	_, err = Db.Exec(`
-	CREATE TABLE IF NOT EXISTS teams (
-    team_name TEXT PRIMARY KEY
-	);
-`)
+	load, err := s.openLoad(s.Db)
 	if err != nil {
-		return err
+		return Team{}, err
 	}
-
-	_, err = Db.Exec(`
-	CREATE TABLE IF NOT EXISTS team_members (
-    team_name TEXT,
-    user_id TEXT,
-    PRIMARY KEY (team_name, user_id),
-    FOREIGN KEY (team_name) REFERENCES teams(team_name) ON DELETE CASCADE,
-    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
-	);
-`)
-	if err != nil {
-		return err
+	for k := range members {
+		members[k].OpenReviews = load[members[k].UserID]
 	}
 
-	_, err = Db.Exec(`
-	CREATE TABLE IF NOT EXISTS pull_requests (
-    pr_id TEXT PRIMARY KEY,
-    pr_name TEXT NOT NULL,
-    author_id TEXT NOT NULL,
-    status TEXT NOT NULL,
-    assigned_reviewers TEXT[],
-    created_at TIMESTAMP NOT NULL,
-    merged_at TIMESTAMP,
-    FOREIGN KEY (author_id) REFERENCES users(user_id)
-	);
-`)
-	return err
+	team.Members = members
+	return team, nil
+
 }
@@ -0,0 +1,14 @@
+package dbtablesgo
+
+// dialect hides the differences between the SQL backends that SQLStore can
+// run on: how reviewer arrays are stored and queried and which set of
+// migrations apply.
+type dialect interface {
+	name() string
+	array(v []string) interface{}
+	scanArray(dst *[]string) interface{}
+	inArray(elem, arr string) string
+	overlaps(a, b string) string
+	forUpdate() string
+	isUniqueViolation(err error) bool
+}
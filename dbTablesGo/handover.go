@@ -0,0 +1,51 @@
+package dbtablesgo
+
+import (
+	"errors"
+
+	"avito_otbor/errs"
+)
+
+// HandedOverPR is one pull request in a HandoverReport.
+type HandedOverPR struct {
+	PullRequestID     string   `json:"pull_request_id"`
+	AuthorID          string   `json:"author_id"`
+	ReplacedBy        string   `json:"replaced_by"`
+	AssignedReviewers []string `json:"assigned_reviewers"`
+	// SuccessorSkipped tells why the successor could not take this review
+	// and the strategy picked someone else, e.g. "author" or "at_capacity".
+	SuccessorSkipped string `json:"successor_skipped,omitempty"`
+}
+
+// HandoverReport lists the OPEN pull requests whose review moved from
+// UserID to a teammate.
+type HandoverReport struct {
+	UserID       string         `json:"user_id"`
+	SuccessorID  string         `json:"successor_id,omitempty"`
+	PullRequests []HandedOverPR `json:"pull_requests"`
+}
+
+// handOver moves the review of prID from userID to successorID using change,
+// a ChangeReviewer of the store. When the successor is not eligible, or none
+// is given, the strategy picks the replacement.
+func handOver(change func(prID, oldReviewerID, newReviewerID string) (*Reassignment, error),
+	prID, userID, successorID string) (HandedOverPR, error) {
+	res, err := change(prID, userID, successorID)
+	skipped := ""
+	if e, ok := errs.As(err); ok && successorID != "" && errors.Is(err, errs.ErrNotEligible) {
+		if len(e.Details) > 0 {
+			skipped = e.Details[0]
+		}
+		res, err = change(prID, userID, "")
+	}
+	if err != nil {
+		return HandedOverPR{}, err
+	}
+	return HandedOverPR{
+		PullRequestID:     prID,
+		AuthorID:          res.PullRequest.AuthorID,
+		ReplacedBy:        res.NewReviewer.UserID,
+		AssignedReviewers: res.PullRequest.AssignedReviewers,
+		SuccessorSkipped:  skipped,
+	}, nil
+}
@@ -0,0 +1,106 @@
+package dbtablesgo
+
+import (
+	"fmt"
+	"time"
+
+	"avito_otbor/errs"
+)
+
+const (
+	StatusDraft  = "DRAFT"
+	StatusOpen   = "OPEN"
+	StatusMerged = "MERGED"
+	StatusClosed = "CLOSED"
+)
+
+// PRAction is a pull request lifecycle transition.
+type PRAction string
+
+const (
+	ActionReady  PRAction = "ready"
+	ActionClose  PRAction = "close"
+	ActionReopen PRAction = "reopen"
+	ActionMerge  PRAction = "merge"
+)
+
+// transitions is the pull request state machine: the statuses each action
+// may start from and the status it leads to. MERGED is final.
+var transitions = map[PRAction]struct {
+	from []string
+	to   string
+}{
+	ActionReady:  {from: []string{StatusDraft}, to: StatusOpen},
+	ActionClose:  {from: []string{StatusDraft, StatusOpen}, to: StatusClosed},
+	ActionReopen: {from: []string{StatusClosed}, to: StatusOpen},
+	ActionMerge:  {from: []string{StatusOpen}, to: StatusMerged},
+}
+
+// transition moves pr along action and reports whether it still needs
+// reviewers, which is the case when a pull request without any becomes OPEN.
+func transition(pr *PullRequest, action PRAction, now time.Time) (bool, error) {
+	t, ok := transitions[action]
+	if !ok {
+		return false, fmt.Errorf("unknown pull request action %q", action)
+	}
+	if action == ActionMerge && pr.Status == StatusMerged {
+		return false, errs.ErrAlreadyMerged.WithIDs(pr.PullRequestID)
+	}
+	if !contains(t.from, pr.Status) {
+		return false, errs.ErrInvalidTransition.
+			WithMessage(fmt.Sprintf("cannot %s pull request in status %s", action, pr.Status)).
+			WithIDs(pr.PullRequestID)
+	}
+	pr.Status = t.to
+	switch t.to {
+	case StatusMerged:
+		pr.MergedAt = &now
+	case StatusClosed:
+		pr.ClosedAt = &now
+	case StatusOpen:
+		pr.ClosedAt = nil
+	}
+	return pr.Status == StatusOpen && len(pr.AssignedReviewers) == 0, nil
+}
+
+// refreshReviewers replaces the reviewers of a reopened pr who were
+// deactivated or left the author's team while it was closed, the way restaff
+// does; a reviewer without a replacement leaves pr understaffed. pool and
+// save are those of restaff.
+func refreshReviewers(a *Assigner, pr *PullRequest, load map[string]int,
+	pool func(exclude []string) (*reviewPool, error), save func(p *reviewPool) error) error {
+	p, err := pool(nil)
+	if err != nil {
+		return err
+	}
+	inactive, gone := p.stale(pr.AssignedReviewers)
+	for _, stale := range []struct {
+		ids   []string
+		cause string
+	}{{inactive, "deactivated"}, {gone, "removed"}} {
+		if len(stale.ids) == 0 {
+			continue
+		}
+		if _, err := restaff(a, pr, stale.ids, stale.cause, DeactivateLenient, load, pool, save); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newPR fills in a pull request being created: a DRAFT stays without
+// reviewers, anything else is opened and staffed out of pool.
+func newPR(a *Assigner, pr *PullRequest, pool *reviewPool, load map[string]int, strict bool) error {
+	pr.CreatedAt = time.Now()
+	pr.MergedAt = nil
+	pr.ClosedAt = nil
+	pr.Reviews = []Review{}
+	if pr.Status == StatusDraft {
+		pr.AssignedReviewers = []string{}
+		pr.Assignment = nil
+		pr.Understaffed = false
+		return nil
+	}
+	pr.Status = StatusOpen
+	return staff(a, pr, pool, load, strict)
+}
@@ -0,0 +1,767 @@
+package dbtablesgo
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"avito_otbor/errs"
+)
+
+// MemoryStore keeps everything in process memory. It is meant for tests and
+// demo mode and mirrors the error semantics of SQLStore.
+type MemoryStore struct {
+	Assigner *Assigner
+	Policy   MergePolicy
+
+	mu      sync.Mutex
+	teams   map[string]*memoryTeam
+	users   map[string]User
+	prs     map[string]PullRequest
+	prOrder []string
+
+	overrides []MergeOverride
+	declines  []Decline
+}
+
+type memoryTeam struct {
+	strategy string
+	required int
+	cursor   string
+	members  []string
+	leads    []string
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Assigner: defaultAssigner(),
+		teams:    make(map[string]*memoryTeam),
+		users:    make(map[string]User),
+		prs:      make(map[string]PullRequest),
+	}
+}
+
+func copyPR(pr PullRequest) PullRequest {
+	pr.AssignedReviewers = append([]string{}, pr.AssignedReviewers...)
+	if pr.MergedAt != nil {
+		t := *pr.MergedAt
+		pr.MergedAt = &t
+	}
+	if pr.ClosedAt != nil {
+		t := *pr.ClosedAt
+		pr.ClosedAt = &t
+	}
+	pr.Reviews = append([]Review{}, pr.Reviews...)
+	if pr.Assignment != nil {
+		a := *pr.Assignment
+		a.Reasons = make(map[string]string, len(pr.Assignment.Reasons))
+		for k, v := range pr.Assignment.Reasons {
+			a.Reasons[k] = v
+		}
+		a.Draws = make(map[string]Draw, len(pr.Assignment.Draws))
+		for k, v := range pr.Assignment.Draws {
+			a.Draws[k] = v
+		}
+		pr.Assignment = &a
+	}
+	return pr
+}
+
+func (m *MemoryStore) TeamAdd(team Team) (*Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[team.TeamName]; ok {
+		return nil, errs.ErrTeamExists.WithIDs(team.TeamName)
+	}
+	m.teams[team.TeamName] = &memoryTeam{strategy: team.ReviewerStrategy, required: team.RequiredReviewers, members: []string{}}
+	for k, value := range team.Members {
+		team.Members[k] = m.upsertMember(team.TeamName, value)
+	}
+	return &team, nil
+}
+
+// upsertMember creates or updates a user and makes them a member of
+// teamName only; the caller holds m.mu.
+func (m *MemoryStore) upsertMember(teamName string, value TeamMember) TeamMember {
+	if value.ReviewWeight <= 0 {
+		value.ReviewWeight = 1
+	}
+	m.users[value.UserID] = User{
+		UserID:         value.UserID,
+		Username:       value.Username,
+		TeamName:       teamName,
+		IsActive:       value.IsActive,
+		ReviewWeight:   value.ReviewWeight,
+		MaxOpenReviews: value.MaxOpenReviews,
+	}
+	for name, t := range m.teams {
+		if name != teamName {
+			t.dropMember(value.UserID)
+		}
+	}
+	t := m.teams[teamName]
+	if !contains(t.members, value.UserID) {
+		t.members = append(t.members, value.UserID)
+	}
+	if value.IsLead && !contains(t.leads, value.UserID) {
+		t.leads = append(t.leads, value.UserID)
+	}
+	if !value.IsLead {
+		t.leads = without(t.leads, value.UserID)
+	}
+	return value
+}
+
+func (t *memoryTeam) dropMember(userID string) {
+	t.members = without(t.members, userID)
+	t.leads = without(t.leads, userID)
+}
+
+func without(ids []string, id string) []string {
+	res := make([]string, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (m *MemoryStore) ListTeams(limit, offset int) (*TeamPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.teams))
+	for name := range m.teams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	page := &TeamPage{Teams: []TeamSummary{}, Total: len(names), Limit: limit, Offset: offset}
+	for k := offset; k < len(names) && k < offset+limit; k++ {
+		t := m.teams[names[k]]
+		page.Teams = append(page.Teams, TeamSummary{
+			TeamName:          names[k],
+			ReviewerStrategy:  t.strategy,
+			RequiredReviewers: t.required,
+			MemberCount:       len(t.members),
+		})
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) GetTeam(teamname string) (Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getTeam(teamname)
+}
+
+// getTeam is GetTeam for a caller that holds m.mu.
+func (m *MemoryStore) getTeam(teamname string) (Team, error) {
+
+	t, ok := m.teams[teamname]
+	if !ok {
+		return Team{}, errs.ErrNotFound.WithMessage("team not found").WithIDs(teamname)
+	}
+	members := []TeamMember{}
+	load := m.openLoad()
+	for _, id := range t.members {
+		u := m.users[id]
+		members = append(members, TeamMember{
+			UserID:         u.UserID,
+			Username:       u.Username,
+			IsActive:       u.IsActive,
+			ReviewWeight:   u.ReviewWeight,
+			MaxOpenReviews: u.MaxOpenReviews,
+			OpenReviews:    load[id],
+			IsLead:         contains(t.leads, id),
+		})
+	}
+	return Team{TeamName: teamname, ReviewerStrategy: t.strategy, RequiredReviewers: t.required, Members: members}, nil
+}
+
+func (m *MemoryStore) AddMembers(teamName string, members []TeamMember) (Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[teamName]; !ok {
+		return Team{}, errs.ErrNotFound.WithMessage("team not found").WithIDs(teamName)
+	}
+	for _, value := range members {
+		m.upsertMember(teamName, value)
+	}
+	return m.getTeam(teamName)
+}
+
+func (m *MemoryStore) RemoveMembers(teamName string, userIDs []string) (Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.teams[teamName]
+	if !ok {
+		return Team{}, errs.ErrNotFound.WithMessage("team not found").WithIDs(teamName)
+	}
+	if missing := notIn(userIDs, m.members(teamName)); len(missing) > 0 {
+		return Team{}, errs.ErrNotFound.WithMessage("users are not members of the team").WithIDs(missing...)
+	}
+	for _, id := range userIDs {
+		t.dropMember(id)
+		u := m.users[id]
+		u.TeamName = ""
+		m.users[id] = u
+	}
+	return m.getTeam(teamName)
+}
+
+// members returns the ids of the users in teamName.
+func (m *MemoryStore) members(teamName string) []string {
+	ids := []string{}
+	for id, u := range m.users {
+		if u.TeamName == teamName {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (m *MemoryStore) RenameTeam(oldName, newName string) (Team, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.teams[oldName]
+	if !ok {
+		return Team{}, errs.ErrNotFound.WithMessage("team not found").WithIDs(oldName)
+	}
+	if _, ok := m.teams[newName]; ok {
+		return Team{}, errs.ErrTeamExists.WithIDs(newName)
+	}
+	for _, id := range m.members(oldName) {
+		u := m.users[id]
+		u.TeamName = newName
+		m.users[id] = u
+	}
+	delete(m.teams, oldName)
+	m.teams[newName] = t
+	return m.getTeam(newName)
+}
+
+func (m *MemoryStore) DeleteTeam(teamName string, policy TeamDeletePolicy) (*TeamDeletionReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[teamName]; !ok {
+		return nil, errs.ErrNotFound.WithMessage("team not found").WithIDs(teamName)
+	}
+	report := newTeamDeletionReport(teamName, policy)
+	report.Members = m.members(teamName)
+	var prs []PullRequest
+	for _, prID := range m.prOrder {
+		if pr := m.prs[prID]; inProgress(pr, report.Members) {
+			prs = append(prs, copyPR(pr))
+		}
+	}
+	if len(prs) > 0 && policy != TeamDeleteClose {
+		return nil, teamInUse(teamName, prs)
+	}
+
+	load := m.openLoad()
+	now := time.Now()
+	updated := make(map[string]PullRequest)
+	cursors := make(map[string]string)
+	for _, pr := range prs {
+		if contains(report.Members, pr.AuthorID) {
+			if _, err := transition(&pr, ActionClose, now); err != nil {
+				return nil, err
+			}
+			updated[pr.PullRequestID] = pr
+			report.ClosedPRs = append(report.ClosedPRs, pr.PullRequestID)
+			continue
+		}
+		affected, err := restaff(m.Assigner, &pr, report.Members, "removed", DeactivateLenient, load,
+			func(exclude []string) (*reviewPool, error) {
+				p, err := m.reviewPool(m.users, pr.AuthorID, exclude)
+				if err != nil {
+					return nil, err
+				}
+				if c, ok := cursors[p.Team]; ok {
+					p.Cursor = c
+				}
+				return p, nil
+			}, func(p *reviewPool) error {
+				if p.moved {
+					cursors[p.Team] = p.Cursor
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+		updated[pr.PullRequestID] = pr
+		report.PullRequests = append(report.PullRequests, affected)
+	}
+
+	for k, v := range updated {
+		m.prs[k] = v
+	}
+	for team, c := range cursors {
+		m.saveCursor(&reviewPool{Team: team, Cursor: c, moved: true})
+	}
+	for _, id := range report.Members {
+		u := m.users[id]
+		u.TeamName = ""
+		m.users[id] = u
+	}
+	delete(m.teams, teamName)
+	return report, nil
+}
+
+func (m *MemoryStore) SetIsActive(userID string, isActive bool) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	user.IsActive = isActive
+	m.users[userID] = user
+	user.OpenReviews = m.openLoad()[userID]
+	return &user, nil
+}
+
+func (m *MemoryStore) GetUser(userID string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	user.OpenReviews = m.openLoad()[userID]
+	return &user, nil
+}
+
+func (m *MemoryStore) SetCapacity(userID string, maxOpenReviews int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(userID)
+	}
+	user.MaxOpenReviews = maxOpenReviews
+	m.users[userID] = user
+	user.OpenReviews = m.openLoad()[userID]
+	return &user, nil
+}
+
+// openLoad counts OPEN pull requests per reviewer.
+func (m *MemoryStore) openLoad() map[string]int {
+	load := make(map[string]int)
+	for _, pr := range m.prs {
+		if pr.Status != StatusOpen {
+			continue
+		}
+		for _, id := range pr.AssignedReviewers {
+			load[id]++
+		}
+	}
+	return load
+}
+
+// reviewPool is the in-memory counterpart of SQLStore.reviewPool, computed
+// over users so that a pending batch of changes can be taken into account.
+func (m *MemoryStore) reviewPool(users map[string]User, authorID string, exclude []string) (*reviewPool, error) {
+	author, ok := users[authorID]
+	if !ok {
+		return nil, errs.ErrAuthorNotFound.WithIDs(authorID)
+	}
+	pool := &reviewPool{Team: author.TeamName}
+	if author.TeamName == "" {
+		// Users without a team don't review for each other, as in SQLStore.
+		return pool, nil
+	}
+	if t, ok := m.teams[author.TeamName]; ok {
+		pool.Strategy = t.strategy
+		pool.Required = t.required
+		if m.Assigner.rotates(t.strategy) {
+			pool.Cursor = t.cursor
+		}
+	}
+	ids := make([]string, 0, len(users))
+	for id, u := range users {
+		if u.TeamName == author.TeamName {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		u := users[id]
+		pool.add(Candidate{UserID: u.UserID, Weight: u.ReviewWeight, Capacity: u.MaxOpenReviews}, u.IsActive, authorID, exclude)
+	}
+	return pool, nil
+}
+
+func (m *MemoryStore) saveCursor(p *reviewPool) {
+	if t, ok := m.teams[p.Team]; ok && p.moved {
+		t.cursor = p.Cursor
+	}
+}
+
+func hasAny(arr []string, targets []string) bool {
+	for _, t := range targets {
+		if contains(arr, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) DeactivateManyUsers(ids []string, mode DeactivationMode) (*DeactivationReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := newDeactivationReport(mode)
+	users := make(map[string]User, len(m.users))
+	for k, v := range m.users {
+		users[k] = v
+	}
+	for _, id := range ids {
+		u, ok := users[id]
+		switch {
+		case !ok:
+			if !contains(report.Unknown, id) {
+				report.Unknown = append(report.Unknown, id)
+			}
+		case u.IsActive:
+			u.IsActive = false
+			users[id] = u
+			report.Deactivated = append(report.Deactivated, id)
+		case !contains(report.Deactivated, id) && !contains(report.AlreadyInactive, id):
+			report.AlreadyInactive = append(report.AlreadyInactive, id)
+		}
+	}
+
+	load := m.openLoad()
+	prs := make(map[string]PullRequest)
+	cursors := make(map[string]string)
+	for _, prID := range m.prOrder {
+		pr := m.prs[prID]
+		if pr.Status != StatusOpen {
+			continue
+		}
+		if !hasAny(pr.AssignedReviewers, ids) {
+			continue
+		}
+		pr = copyPR(pr)
+		affected, err := restaff(m.Assigner, &pr, ids, "deactivated", mode, load, func(exclude []string) (*reviewPool, error) {
+			p, err := m.reviewPool(users, pr.AuthorID, exclude)
+			if err != nil {
+				return nil, err
+			}
+			if c, ok := cursors[p.Team]; ok {
+				p.Cursor = c
+			}
+			return p, nil
+		}, func(p *reviewPool) error {
+			if p.moved {
+				cursors[p.Team] = p.Cursor
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		report.PullRequests = append(report.PullRequests, affected)
+		prs[prID] = pr
+	}
+
+	m.users = users
+	for k, v := range prs {
+		m.prs[k] = v
+	}
+	for team, c := range cursors {
+		m.saveCursor(&reviewPool{Team: team, Cursor: c, moved: true})
+	}
+	return report, nil
+}
+
+func (m *MemoryStore) CreatePR(pr *PullRequest, strict bool) (*PullRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.prs[pr.PullRequestID]; ok {
+		return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+	}
+	if _, ok := m.users[pr.AuthorID]; !ok {
+		return nil, errs.ErrAuthorNotFound.WithIDs(pr.AuthorID)
+	}
+	pool, err := m.reviewPool(m.users, pr.AuthorID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := newPR(m.Assigner, pr, pool, m.openLoad(), strict); err != nil {
+		return nil, err
+	}
+	m.saveCursor(pool)
+	m.prs[pr.PullRequestID] = copyPR(*pr)
+	m.prOrder = append(m.prOrder, pr.PullRequestID)
+	return pr, nil
+}
+
+func (m *MemoryStore) PreviewPR(pr PullRequest, strict bool) (*AssignmentPreview, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.prs[pr.PullRequestID]; ok {
+		return nil, errs.ErrPRExists.WithIDs(pr.PullRequestID)
+	}
+	pool, err := m.reviewPool(m.users, pr.AuthorID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return preview(m.Assigner, pr, pool, m.openLoad(), strict)
+}
+
+func (m *MemoryStore) GetPR(prID string) (*PullRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pr, ok := m.prs[prID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
+	pr = copyPR(pr)
+	return &pr, nil
+}
+
+func (m *MemoryStore) SubmitReview(prID, reviewerID, verdict string) (*PullRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pr, ok := m.prs[prID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
+	pr = copyPR(pr)
+	lead := contains(m.teamLeads(pr.AuthorID), reviewerID)
+	if err := review(&pr, reviewerID, verdict, lead, time.Now()); err != nil {
+		return nil, err
+	}
+	m.prs[prID] = copyPR(pr)
+	return &pr, nil
+}
+
+func (m *MemoryStore) StatusMerged(prID string, override *MergeOverride) (*PullRequest, error) {
+	return m.transition(prID, ActionMerge, override)
+}
+
+func (m *MemoryStore) Transition(prID string, action PRAction) (*PullRequest, error) {
+	return m.transition(prID, action, nil)
+}
+
+func (m *MemoryStore) teamLeads(authorID string) []string {
+	if t, ok := m.teams[m.users[authorID].TeamName]; ok {
+		return t.leads
+	}
+	return nil
+}
+
+func (m *MemoryStore) MergeOverrides(prID string) ([]MergeOverride, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := []MergeOverride{}
+	for _, o := range m.overrides {
+		if prID == "" || o.PullRequestID == prID {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) transition(prID string, action PRAction, override *MergeOverride) (*PullRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pr, ok := m.prs[prID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
+	pr = copyPR(pr)
+	now := time.Now()
+	var audit *MergeOverride
+	if action == ActionMerge {
+		var err error
+		audit, err = gateMerge(m.Policy, &pr, m.teamLeads(pr.AuthorID), override, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	needsReviewers, err := transition(&pr, action, now)
+	if err != nil {
+		return nil, err
+	}
+	if needsReviewers {
+		pool, err := m.reviewPool(m.users, pr.AuthorID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := staff(m.Assigner, &pr, pool, m.openLoad(), false); err != nil {
+			return nil, err
+		}
+		m.saveCursor(pool)
+	} else if action == ActionReopen {
+		err := refreshReviewers(m.Assigner, &pr, m.openLoad(), func(exclude []string) (*reviewPool, error) {
+			return m.reviewPool(m.users, pr.AuthorID, exclude)
+		}, func(p *reviewPool) error {
+			m.saveCursor(p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if audit != nil {
+		m.overrides = append(m.overrides, *audit)
+	}
+	m.prs[prID] = copyPR(pr)
+	return &pr, nil
+}
+
+func (m *MemoryStore) ChangeReviewer(prID, oldReviewerID, newReviewerID string) (*Reassignment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.changeReviewer(prID, oldReviewerID, newReviewerID)
+}
+
+func (m *MemoryStore) DeclineReview(prID, reviewerID, reason string) (*Reassignment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.changeReviewer(prID, reviewerID, "")
+	if err != nil {
+		return nil, err
+	}
+	res.Decline = &Decline{
+		PullRequestID: prID,
+		UserID:        reviewerID,
+		ReplacedBy:    res.NewReviewer.UserID,
+		Reason:        reason,
+		CreatedAt:     time.Now(),
+	}
+	m.declines = append(m.declines, *res.Decline)
+	return res, nil
+}
+
+func (m *MemoryStore) HandOverReviews(userID, successorID string) (*HandoverReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range []string{userID, successorID} {
+		if _, ok := m.users[id]; id != "" && !ok {
+			return nil, errs.ErrNotFound.WithMessage("user not found").WithIDs(id)
+		}
+	}
+
+	// changeReviewer writes every PR as it goes, so keep what to restore if
+	// a later one can't be handed over.
+	prs := make(map[string]PullRequest, len(m.prs))
+	for k, v := range m.prs {
+		prs[k] = v
+	}
+	cursors := make(map[string]string, len(m.teams))
+	for name, t := range m.teams {
+		cursors[name] = t.cursor
+	}
+	rollback := func() {
+		m.prs = prs
+		for name, c := range cursors {
+			m.teams[name].cursor = c
+		}
+	}
+
+	report := &HandoverReport{UserID: userID, SuccessorID: successorID, PullRequests: []HandedOverPR{}}
+	for _, prID := range m.prOrder {
+		pr := m.prs[prID]
+		if pr.Status != StatusOpen || !contains(pr.AssignedReviewers, userID) {
+			continue
+		}
+		handed, err := handOver(m.changeReviewer, prID, userID, successorID)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		report.PullRequests = append(report.PullRequests, handed)
+	}
+	return report, nil
+}
+
+// changeReviewer does ChangeReviewer; the caller holds m.mu.
+func (m *MemoryStore) changeReviewer(prID, oldReviewerID, newReviewerID string) (*Reassignment, error) {
+	pr, ok := m.prs[prID]
+	if !ok {
+		return nil, errs.ErrNotFound.WithMessage("pull request not found").WithIDs(prID)
+	}
+	if pr.Status == StatusMerged {
+		return nil, errs.ErrPRMerged.WithIDs(prID)
+	}
+	if pr.Status != StatusOpen {
+		return nil, errs.ErrNotOpen.WithIDs(prID)
+	}
+	if !contains(pr.AssignedReviewers, oldReviewerID) {
+		return nil, errs.ErrNotAssigned.WithIDs(prID, oldReviewerID)
+	}
+	pool, err := m.reviewPool(m.users, pr.AuthorID, pr.AssignedReviewers)
+	if err != nil {
+		return nil, err
+	}
+	var picked Pick
+	var dr Draw
+	if newReviewerID != "" {
+		if picked, err = pool.choose(newReviewerID, m.openLoad()); err != nil {
+			return nil, err
+		}
+	} else {
+		var picks []Pick
+		picks, dr = m.Assigner.pick(pool, m.openLoad(), 1)
+		if len(picks) == 0 {
+			return nil, errs.ErrNoReplacement.WithIDs(prID, oldReviewerID)
+		}
+		picked = picks[0]
+	}
+	m.saveCursor(pool)
+	pr = copyPR(pr)
+	newID := reassign(&pr, oldReviewerID, picked, dr)
+	m.prs[prID] = copyPR(pr)
+	return &Reassignment{
+		PullRequest: &pr,
+		OldReviewer: m.reviewer(oldReviewerID),
+		NewReviewer: m.reviewer(newID),
+	}, nil
+}
+
+func (m *MemoryStore) reviewer(userID string) Reviewer {
+	u := m.users[userID]
+	return Reviewer{UserID: userID, Username: u.Username, TeamName: u.TeamName}
+}
+
+func (m *MemoryStore) GetReview(userID string) ([]PullRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []PullRequest{}
+	for _, prID := range m.prOrder {
+		pr := m.prs[prID]
+		if contains(pr.AssignedReviewers, userID) {
+			result = append(result, copyPR(pr))
+		}
+	}
+	if len(result) == 0 {
+		return nil, errs.ErrNotFound.WithMessage("user has no assigned pull requests").WithIDs(userID)
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) GetStats(filter StatsFilter) (*Stats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	teamOf := make(map[string]string, len(m.users))
+	for id, u := range m.users {
+		teamOf[id] = u.TeamName
+	}
+	var prs []PullRequest
+	for _, prID := range m.prOrder {
+		pr := m.prs[prID]
+		if filter.match(pr, teamOf[pr.AuthorID]) {
+			prs = append(prs, pr)
+		}
+	}
+	return buildStats(prs, teamOf, m.declines), nil
+}
@@ -0,0 +1,123 @@
+package dbtablesgo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"avito_otbor/errs"
+)
+
+// What a pull request may lack before it can be merged, see MergePolicy.
+const (
+	MissingApprovals    = "APPROVALS"
+	MissingNoChanges    = "NO_CHANGE_REQUESTS"
+	MissingLeadApproval = "LEAD_APPROVAL"
+)
+
+// MergePolicy is what an OPEN pull request needs before it can be merged.
+// The zero value only blocks merges with outstanding change requests.
+type MergePolicy struct {
+	// MinApprovals counts approvals of assigned reviewers only. A lead who
+	// isn't assigned can give the lead approval but doesn't add to it.
+	MinApprovals        int
+	AllowChangeRequests bool
+	// RequireLeadApproval asks for an approval from a lead of the author's
+	// team.
+	RequireLeadApproval bool
+}
+
+// MergePolicyFromEnv reads MERGE_MIN_APPROVALS, MERGE_ALLOW_CHANGE_REQUESTS
+// and MERGE_REQUIRE_LEAD.
+func MergePolicyFromEnv() (MergePolicy, error) {
+	var p MergePolicy
+	if v := os.Getenv("MERGE_MIN_APPROVALS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("bad MERGE_MIN_APPROVALS %q", v)
+		}
+		p.MinApprovals = n
+	}
+	for _, f := range []struct {
+		name string
+		dst  *bool
+	}{{"MERGE_ALLOW_CHANGE_REQUESTS", &p.AllowChangeRequests}, {"MERGE_REQUIRE_LEAD", &p.RequireLeadApproval}} {
+		v := os.Getenv(f.name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("bad %s %q", f.name, v)
+		}
+		*f.dst = b
+	}
+	return p, nil
+}
+
+// MergeOverride is an admin merge that bypassed the policy. Every override is
+// kept in the audit log together with what the pull request was missing.
+type MergeOverride struct {
+	PullRequestID string    `json:"pull_request_id"`
+	Actor         string    `json:"actor"`
+	Reason        string    `json:"reason"`
+	Missing       []string  `json:"missing"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// missing returns the policy items pr lacks and a readable note for each;
+// leads are the team leads of the author's team.
+func (p MergePolicy) missing(pr *PullRequest, leads []string) ([]string, []string) {
+	approvals := 0
+	leadApproved := false
+	for _, r := range pr.Reviews {
+		if r.Verdict != VerdictApproved {
+			continue
+		}
+		if contains(pr.AssignedReviewers, r.ReviewerID) {
+			approvals++
+		}
+		leadApproved = leadApproved || contains(leads, r.ReviewerID)
+	}
+	missing, notes := []string{}, []string{}
+	if approvals < p.MinApprovals {
+		missing = append(missing, MissingApprovals)
+		notes = append(notes, fmt.Sprintf("%d of %d approvals", approvals, p.MinApprovals))
+	}
+	if ids := pr.changesRequested(); !p.AllowChangeRequests && len(ids) > 0 {
+		missing = append(missing, MissingNoChanges)
+		notes = append(notes, "changes requested by "+strings.Join(ids, ", "))
+	}
+	if p.RequireLeadApproval && !leadApproved {
+		missing = append(missing, MissingLeadApproval)
+		notes = append(notes, "no approval from a team lead")
+	}
+	return missing, notes
+}
+
+// gateMerge checks an OPEN pr against the policy before it is merged. A pull
+// request that falls short fails with MERGE_BLOCKED unless override is set;
+// an override is returned filled in for the audit log, even when nothing was
+// missing.
+func gateMerge(p MergePolicy, pr *PullRequest, leads []string, override *MergeOverride, now time.Time) (*MergeOverride, error) {
+	if pr.Status != StatusOpen {
+		return nil, nil
+	}
+	missing, notes := p.missing(pr, leads)
+	if override != nil {
+		o := *override
+		o.PullRequestID = pr.PullRequestID
+		o.Missing = missing
+		o.CreatedAt = now
+		return &o, nil
+	}
+	if len(missing) > 0 {
+		return nil, errs.ErrMergeBlocked.
+			WithMessage("merge policy not met: " + strings.Join(notes, "; ")).
+			WithIDs(pr.PullRequestID).
+			WithDetails(missing...)
+	}
+	return nil, nil
+}
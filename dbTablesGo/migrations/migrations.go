@@ -0,0 +1,242 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockKey is the pg_advisory_lock key held while migrations run, so that
+// replicas starting at the same time don't migrate concurrently.
+const lockKey = 20251101
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+}
+
+// Load reads the NNNN_name.up.sql / NNNN_name.down.sql pairs of a dialect
+// ordered by version.
+func Load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("unknown migrations dialect %q: %w", dialect, err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %q: %w", name, err)
+		}
+		body, err := files.ReadFile(dialect + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	ms, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: ms}, nil
+}
+
+// Up applies every pending migration and returns the applied versions.
+func (m *Migrator) Up() ([]int, error) {
+	var applied []int
+	err := m.locked(func(conn *sql.Conn) error {
+		for _, mig := range m.migrations {
+			ok, err := m.apply(conn, mig)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			if ok {
+				applied = append(applied, mig.Version)
+			}
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations and returns the
+// reverted versions.
+func (m *Migrator) Down(steps int) ([]int, error) {
+	var reverted []int
+	err := m.locked(func(conn *sql.Conn) error {
+		for i := 0; i < steps; i++ {
+			version, err := m.current(conn)
+			if err != nil {
+				return err
+			}
+			if version == 0 {
+				return nil
+			}
+			mig, ok := m.find(version)
+			if !ok {
+				return fmt.Errorf("no migration files for applied version %d", version)
+			}
+			if err := m.revert(conn, mig); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			reverted = append(reverted, version)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Version returns the latest applied migration, 0 for an empty database.
+func (m *Migrator) Version() (int, error) {
+	var version int
+	err := m.locked(func(conn *sql.Conn) error {
+		var err error
+		version, err = m.current(conn)
+		return err
+	})
+	return version, err
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// locked runs fn on a single connection. On Postgres the connection holds an
+// advisory lock for the whole run; SQLite serialises writers itself and every
+// migration runs in its own write transaction that re-checks the version.
+func (m *Migrator) locked(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect == "postgres" {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockKey)
+		}()
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) current(conn *sql.Conn) (int, error) {
+	var version sql.NullInt64
+	err := conn.QueryRowContext(context.Background(), `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+func (m *Migrator) apply(conn *sql.Conn, mig Migration) (bool, error) {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, mig.Version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		mig.Version, mig.Name, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (m *Migrator) revert(conn *sql.Conn, mig Migration) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS users (
+    user_id TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    team_name TEXT,
+    is_active BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS teams (
+    team_name TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS team_members (
+    team_name TEXT,
+    user_id TEXT,
+    PRIMARY KEY (team_name, user_id),
+    FOREIGN KEY (team_name) REFERENCES teams(team_name) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS pull_requests (
+    pr_id TEXT PRIMARY KEY,
+    pr_name TEXT NOT NULL,
+    author_id TEXT NOT NULL,
+    status TEXT NOT NULL,
+    assigned_reviewers TEXT[],
+    created_at TIMESTAMP NOT NULL,
+    merged_at TIMESTAMP,
+    FOREIGN KEY (author_id) REFERENCES users(user_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN review_weight;
+ALTER TABLE teams DROP COLUMN reviewer_strategy;
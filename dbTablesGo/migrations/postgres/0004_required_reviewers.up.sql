@@ -0,0 +1,2 @@
+ALTER TABLE teams ADD COLUMN required_reviewers INTEGER;
+ALTER TABLE pull_requests ADD COLUMN required_reviewers INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE teams ADD COLUMN rotation_cursor TEXT;
@@ -0,0 +1 @@
+ALTER TABLE pull_requests ADD COLUMN reviews TEXT NOT NULL DEFAULT '[]';
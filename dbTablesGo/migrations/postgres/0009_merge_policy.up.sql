@@ -0,0 +1,10 @@
+ALTER TABLE team_members ADD COLUMN is_lead BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE merge_overrides (
+    id BIGSERIAL PRIMARY KEY,
+    pr_id TEXT NOT NULL REFERENCES pull_requests(pr_id),
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    missing TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE review_declines (
+    id BIGSERIAL PRIMARY KEY,
+    pr_id TEXT NOT NULL REFERENCES pull_requests(pr_id),
+    user_id TEXT NOT NULL,
+    replaced_by TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_review_declines_user ON review_declines(user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE pull_requests DROP COLUMN required_reviewers;
+ALTER TABLE teams DROP COLUMN required_reviewers;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN max_open_reviews INTEGER;
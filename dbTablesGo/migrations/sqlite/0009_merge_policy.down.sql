@@ -0,0 +1,2 @@
+DROP TABLE merge_overrides;
+ALTER TABLE team_members DROP COLUMN is_lead;
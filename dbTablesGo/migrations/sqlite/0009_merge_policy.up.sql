@@ -0,0 +1,10 @@
+ALTER TABLE team_members ADD COLUMN is_lead BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE merge_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    pr_id TEXT NOT NULL REFERENCES pull_requests(pr_id),
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    missing TEXT NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,58 @@
+package dbtablesgo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+type postgresDialect struct{}
+
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{Db: db, Assigner: defaultAssigner(), d: postgresDialect{}}
+}
+
+func GetConnection() string {
+	host := os.Getenv("DB_HOST")
+	port := os.Getenv("DB_PORT")
+	user := os.Getenv("DB_USER")
+	pass := os.Getenv("DB_PASS")
+	name := os.Getenv("DB_NAME")
+
+	return fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		user, pass, host, port, name,
+	)
+}
+
+func (postgresDialect) array(v []string) interface{} {
+	return pq.Array(v)
+}
+
+func (postgresDialect) scanArray(dst *[]string) interface{} {
+	return pq.Array(dst)
+}
+
+func (postgresDialect) inArray(elem, arr string) string {
+	return fmt.Sprintf("%s = ANY(%s)", elem, arr)
+}
+
+func (postgresDialect) overlaps(a, b string) string {
+	return fmt.Sprintf("%s && %s", a, b)
+}
+
+func (postgresDialect) forUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (postgresDialect) isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (postgresDialect) name() string {
+	return "postgres"
+}
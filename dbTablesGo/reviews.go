@@ -0,0 +1,72 @@
+package dbtablesgo
+
+import (
+	"time"
+
+	"avito_otbor/errs"
+)
+
+const (
+	VerdictApproved         = "APPROVED"
+	VerdictChangesRequested = "CHANGES_REQUESTED"
+)
+
+// Review is the latest verdict of a reviewer on a pull request.
+type Review struct {
+	ReviewerID  string    `json:"reviewer_id"`
+	Verdict     string    `json:"verdict"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+// Decline is a reviewer taking themselves off a pull request, with the
+// teammate that replaced them.
+type Decline struct {
+	PullRequestID string    `json:"pull_request_id"`
+	UserID        string    `json:"user_id"`
+	ReplacedBy    string    `json:"replaced_by"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// review records the verdict of reviewerID on pr, replacing their earlier one.
+// Only assigned reviewers of an OPEN pull request and leads of the author's
+// team may review, and never the author, lead or not.
+func review(pr *PullRequest, reviewerID, verdict string, lead bool, now time.Time) error {
+	switch {
+	case pr.Status == StatusMerged:
+		return errs.ErrPRMerged.WithIDs(pr.PullRequestID)
+	case pr.Status != StatusOpen:
+		return errs.ErrNotOpen.WithIDs(pr.PullRequestID)
+	case reviewerID == pr.AuthorID:
+		return errs.ErrNotEligible.WithMessage("authors can't review their own pull request").
+			WithIDs(pr.PullRequestID, reviewerID).
+			WithDetails(ExcludedAuthor)
+	case !lead && !contains(pr.AssignedReviewers, reviewerID):
+		return errs.ErrNotAssigned.WithIDs(pr.PullRequestID, reviewerID)
+	}
+	pr.dropReview(reviewerID)
+	pr.Reviews = append(pr.Reviews, Review{ReviewerID: reviewerID, Verdict: verdict, SubmittedAt: now})
+	return nil
+}
+
+// dropReview forgets the verdict of a reviewer taken off pr.
+func (pr *PullRequest) dropReview(reviewerID string) {
+	kept := []Review{}
+	for _, r := range pr.Reviews {
+		if r.ReviewerID != reviewerID {
+			kept = append(kept, r)
+		}
+	}
+	pr.Reviews = kept
+}
+
+// changesRequested lists the reviewers that asked for changes.
+func (pr *PullRequest) changesRequested() []string {
+	var ids []string
+	for _, r := range pr.Reviews {
+		if r.Verdict == VerdictChangesRequested {
+			ids = append(ids, r.ReviewerID)
+		}
+	}
+	return ids
+}
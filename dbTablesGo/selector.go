@@ -0,0 +1,542 @@
+package dbtablesgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"avito_otbor/errs"
+)
+
+const (
+	StrategyRandom      = "random"
+	StrategyLeastLoaded = "least_loaded"
+	StrategyRoundRobin  = "round_robin"
+	StrategyWeighted    = "weighted"
+)
+
+// Candidate is an active teammate that may be assigned as a reviewer.
+type Candidate struct {
+	UserID      string `json:"user_id"`
+	OpenReviews int    `json:"open_reviews"`
+	Weight      int    `json:"review_weight"`
+	Capacity    int    `json:"max_open_reviews,omitempty"`
+}
+
+// Pick is a chosen reviewer together with the reason they were chosen.
+type Pick struct {
+	UserID string
+	Reason string
+}
+
+// ReviewerSelector picks up to n reviewers out of candidates. Candidates come
+// ordered by user_id; cursor is the teammate who was picked last by a
+// rotating strategy, empty when the team hasn't rotated yet. Every random
+// choice must come from rnd so that a pick can be replayed from its seed.
+type ReviewerSelector interface {
+	Name() string
+	Select(rnd *rand.Rand, cursor string, candidates []Candidate, n int) []Pick
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]ReviewerSelector)
+)
+
+func init() {
+	Register(randomSelector{})
+	Register(leastLoadedSelector{})
+	Register(roundRobinSelector{})
+	Register(weightedSelector{})
+}
+
+// Register makes sel available as a reviewer strategy under sel.Name(), for
+// the global setting as well as for teams. Like database/sql.Register it
+// panics on an empty or duplicate name.
+func Register(sel ReviewerSelector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	name := sel.Name()
+	if name == "" {
+		panic("dbtablesgo: Register selector with empty name")
+	}
+	if _, dup := registry[name]; dup {
+		panic("dbtablesgo: Register called twice for strategy " + name)
+	}
+	registry[name] = sel
+}
+
+// Registered tells whether a strategy called name has been registered.
+func Registered(name string) bool {
+	_, ok := lookupSelector(name)
+	return ok
+}
+
+func lookupSelector(name string) (ReviewerSelector, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	sel, ok := registry[name]
+	return sel, ok
+}
+
+type randomSelector struct{}
+
+func (randomSelector) Name() string { return StrategyRandom }
+
+func (randomSelector) Select(rnd *rand.Rand, _ string, candidates []Candidate, n int) []Pick {
+	ids := candidateIDs(candidates)
+	rnd.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	var res []Pick
+	for _, id := range ids[:min(n, len(ids))] {
+		res = append(res, Pick{UserID: id, Reason: fmt.Sprintf("random pick among %d candidates", len(ids))})
+	}
+	return res
+}
+
+// leastLoadedSelector prefers candidates with fewer OPEN reviews, ties go to
+// the smallest user_id.
+type leastLoadedSelector struct{}
+
+func (leastLoadedSelector) Name() string { return StrategyLeastLoaded }
+
+func (leastLoadedSelector) Select(_ *rand.Rand, _ string, candidates []Candidate, n int) []Pick {
+	sorted := append([]Candidate{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].OpenReviews != sorted[j].OpenReviews {
+			return sorted[i].OpenReviews < sorted[j].OpenReviews
+		}
+		return sorted[i].UserID < sorted[j].UserID
+	})
+	var res []Pick
+	for k, c := range sorted[:min(n, len(sorted))] {
+		reason := fmt.Sprintf("%d open reviews, least loaded of %d candidates", c.OpenReviews, len(sorted))
+		var tied []string
+		for _, o := range sorted[k+1:] {
+			if o.OpenReviews == c.OpenReviews {
+				tied = append(tied, o.UserID)
+			}
+		}
+		if len(tied) > 0 {
+			reason += fmt.Sprintf("; tie with %s broken by user_id", strings.Join(tied, ", "))
+		}
+		res = append(res, Pick{UserID: c.UserID, Reason: reason})
+	}
+	return res
+}
+
+// roundRobinSelector hands reviews out in user_id order, continuing after
+// the cursor. The cursor itself is kept by the store, see reviewPool.
+type roundRobinSelector struct{}
+
+func (roundRobinSelector) Name() string { return StrategyRoundRobin }
+
+func (roundRobinSelector) Select(_ *rand.Rand, cursor string, candidates []Candidate, n int) []Pick {
+	ids := candidateIDs(candidates)
+	prev := cursor
+	start := sort.SearchStrings(ids, prev+"\x00")
+	var res []Pick
+	for i := 0; i < len(ids) && len(res) < n; i++ {
+		reason := "first in rotation"
+		if prev != "" {
+			reason = "next in rotation after " + prev
+		}
+		id := ids[(start+i)%len(ids)]
+		res = append(res, Pick{UserID: id, Reason: reason})
+		prev = id
+	}
+	return res
+}
+
+// weightedSelector draws reviewers at random with probability proportional
+// to their review weight.
+type weightedSelector struct{}
+
+func (weightedSelector) Name() string { return StrategyWeighted }
+
+func (weightedSelector) Select(rnd *rand.Rand, _ string, candidates []Candidate, n int) []Pick {
+	pool := append([]Candidate{}, candidates...)
+	var res []Pick
+	for len(res) < n && len(pool) > 0 {
+		total := 0
+		for _, c := range pool {
+			total += max(c.Weight, 1)
+		}
+		r := rnd.Intn(total)
+		k := 0
+		for ; k < len(pool)-1; k++ {
+			r -= max(pool[k].Weight, 1)
+			if r < 0 {
+				break
+			}
+		}
+		res = append(res, Pick{
+			UserID: pool[k].UserID,
+			Reason: fmt.Sprintf("weighted draw, weight %d of %d", max(pool[k].Weight, 1), total),
+		})
+		pool = append(pool[:k], pool[k+1:]...)
+	}
+	return res
+}
+
+type reviewPool struct {
+	Team       string
+	Strategy   string
+	Required   int
+	Candidates []Candidate
+	Excluded   []Exclusion
+	// Cursor is the team's rotation cursor, loaded only for teams that
+	// rotate. moved is set once pick advances it and it has to be saved.
+	Cursor string
+	moved  bool
+	// dryRun makes pick use the next seed without consuming it.
+	dryRun bool
+}
+
+// add puts a teammate among the candidates or records why they can't review.
+func (p *reviewPool) add(c Candidate, active bool, authorID string, exclude []string) {
+	switch {
+	case c.UserID == authorID:
+		p.Excluded = append(p.Excluded, Exclusion{UserID: c.UserID, Reason: ExcludedAuthor})
+	case !active:
+		p.Excluded = append(p.Excluded, Exclusion{UserID: c.UserID, Reason: ExcludedInactive})
+	case contains(exclude, c.UserID):
+		p.Excluded = append(p.Excluded, Exclusion{UserID: c.UserID, Reason: ExcludedAssigned})
+	default:
+		p.Candidates = append(p.Candidates, c)
+	}
+}
+
+// withLoad returns the candidates with their OPEN review counts from load,
+// leaving out those already at capacity.
+func (p *reviewPool) withLoad(load map[string]int) []Candidate {
+	res := make([]Candidate, 0, len(p.Candidates))
+	for _, c := range p.Candidates {
+		c.OpenReviews = load[c.UserID]
+		if c.Capacity > 0 && c.OpenReviews >= c.Capacity {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// stale returns the reviewers that can't review for the pool's team any
+// more: inactive teammates and users who are no longer on the team.
+func (p *reviewPool) stale(reviewers []string) (inactive, gone []string) {
+	for _, id := range reviewers {
+		reason := ExcludedNotMember
+		for _, e := range p.Excluded {
+			if e.UserID == id {
+				reason = e.Reason
+			}
+		}
+		for _, c := range p.Candidates {
+			if c.UserID == id {
+				reason = ""
+			}
+		}
+		switch reason {
+		case ExcludedInactive:
+			inactive = append(inactive, id)
+		case ExcludedNotMember, ExcludedAuthor:
+			gone = append(gone, id)
+		}
+	}
+	return inactive, gone
+}
+
+// choose picks userID, asked for by name, out of p or reports why they can't
+// review.
+func (p *reviewPool) choose(userID string, load map[string]int) (Pick, error) {
+	reason := ExcludedNotMember
+	for _, e := range p.Excluded {
+		if e.UserID == userID {
+			reason = e.Reason
+		}
+	}
+	for _, c := range p.Candidates {
+		if c.UserID != userID {
+			continue
+		}
+		if c.Capacity > 0 && load[userID] >= c.Capacity {
+			reason = ExcludedCapacity
+			break
+		}
+		return Pick{UserID: userID, Reason: "requested explicitly"}, nil
+	}
+	return Pick{}, errs.ErrNotEligible.
+		WithMessage(fmt.Sprintf("user %s can't review: %s", userID, reason)).
+		WithIDs(userID).
+		WithDetails(reason)
+}
+
+func candidateIDs(candidates []Candidate) []string {
+	ids := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		ids = append(ids, c.UserID)
+	}
+	return ids
+}
+
+// DefaultReviewers is the number of reviewers a pull request gets when
+// neither the request nor the team asks for another one.
+const DefaultReviewers = 2
+
+// Assigner holds the reviewer selection settings shared by the stores: the
+// strategy and the reviewer count used for teams without their own. The
+// strategies themselves come from Register.
+type Assigner struct {
+	Strategy  string
+	Reviewers int
+
+	// Every pick gets its own seed drawn from src; next is the seed the
+	// following pick will use.
+	mu   sync.Mutex
+	src  rand.Source
+	next int64
+}
+
+func NewAssigner(strategy string) (*Assigner, error) {
+	a := &Assigner{
+		Strategy:  strategy,
+		Reviewers: DefaultReviewers,
+	}
+	if a.Strategy == "" {
+		a.Strategy = StrategyRandom
+	}
+	if !a.Has(a.Strategy) {
+		return nil, fmt.Errorf("unknown reviewer strategy %q", a.Strategy)
+	}
+	a.SetSource(rand.NewSource(time.Now().UnixNano()))
+	return a, nil
+}
+
+// SetSource makes every following pick derive its seed from src.
+func (a *Assigner) SetSource(src rand.Source) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.src = src
+	a.next = src.Int63()
+}
+
+// SetSeed is SetSource with a math/rand source seeded with seed, so the same
+// seed and the same requests give the same assignments.
+func (a *Assigner) SetSeed(seed int64) {
+	a.SetSource(rand.NewSource(seed))
+}
+
+// seed returns the seed for the next pick; consume moves on to the one after.
+func (a *Assigner) seed(consume bool) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seed := a.next
+	if consume {
+		a.next = a.src.Int63()
+	}
+	return seed
+}
+
+// AssignerFromEnv reads the global strategy from REVIEWER_STRATEGY, the
+// reviewer count from REVIEWERS_PER_PR and the random seed from
+// REVIEWER_SEED.
+func AssignerFromEnv() (*Assigner, error) {
+	a, err := NewAssigner(os.Getenv("REVIEWER_STRATEGY"))
+	if err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("REVIEWERS_PER_PR"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad REVIEWERS_PER_PR %q", v)
+		}
+		a.Reviewers = n
+	}
+	if v := os.Getenv("REVIEWER_SEED"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad REVIEWER_SEED %q", v)
+		}
+		a.SetSeed(seed)
+	}
+	return a, nil
+}
+
+func defaultAssigner() *Assigner {
+	a, _ := NewAssigner(StrategyRandom)
+	return a
+}
+
+// Has tells whether strategy is registered.
+func (a *Assigner) Has(strategy string) bool {
+	return Registered(strategy)
+}
+
+// required resolves the reviewer count: the request's own, then the team's,
+// then the global one.
+func (a *Assigner) required(requested, team int) int {
+	switch {
+	case requested > 0:
+		return requested
+	case team > 0:
+		return team
+	}
+	return a.Reviewers
+}
+
+// selector returns the team's own strategy when it has one.
+func (a *Assigner) selector(teamStrategy string) ReviewerSelector {
+	if sel, ok := lookupSelector(teamStrategy); ok {
+		return sel
+	}
+	sel, _ := lookupSelector(a.Strategy)
+	return sel
+}
+
+// rotates tells whether teams with teamStrategy keep a rotation cursor.
+func (a *Assigner) rotates(teamStrategy string) bool {
+	return a.selector(teamStrategy).Name() == StrategyRoundRobin
+}
+
+// Seed is the seed of a pick's random source. It is written to JSON as a
+// string, since most seeds don't fit in the 53 bits a float64 keeps exact;
+// plain numbers saved before are still read.
+type Seed int64
+
+func (s Seed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(s), 10))
+}
+
+func (s *Seed) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("seed: %w", err)
+		}
+		*s = Seed(n)
+		return nil
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return fmt.Errorf("seed: %w", err)
+	}
+	*s = Seed(n)
+	return nil
+}
+
+// Draw identifies a pick: the strategy that made it and the seed of its
+// random source. Running the strategy with the seed on the same candidates
+// gives the same pick.
+type Draw struct {
+	Strategy string `json:"strategy"`
+	Seed     Seed   `json:"seed"`
+}
+
+// pick chooses up to n reviewers out of p and returns them with the draw
+// that made the choice; every reason is prefixed with the strategy name. For
+// rotating teams the cursor of p is moved to the last pick.
+func (a *Assigner) pick(p *reviewPool, load map[string]int, n int) ([]Pick, Draw) {
+	sel := a.selector(p.Strategy)
+	candidates := p.withLoad(load)
+	if n <= 0 || len(candidates) == 0 {
+		return []Pick{}, Draw{Strategy: sel.Name()}
+	}
+	d := Draw{Strategy: sel.Name(), Seed: Seed(a.seed(!p.dryRun))}
+	picks := sel.Select(rand.New(rand.NewSource(int64(d.Seed))), p.Cursor, candidates, n)
+	for k := range picks {
+		picks[k].Reason = sel.Name() + ": " + picks[k].Reason
+	}
+	if a.rotates(p.Strategy) && len(picks) > 0 {
+		p.Cursor = picks[len(picks)-1].UserID
+		p.moved = true
+	}
+	return picks, d
+}
+
+func pickIDs(picks []Pick) []string {
+	ids := make([]string, 0, len(picks))
+	for _, p := range picks {
+		ids = append(ids, p.UserID)
+	}
+	return ids
+}
+
+func newAssignment(d Draw, picks []Pick) *Assignment {
+	a := &Assignment{Strategy: d.Strategy, Seed: d.Seed}
+	for _, p := range picks {
+		a.replace("", p.UserID, p.Reason, d)
+	}
+	return a
+}
+
+// reassign puts p in place of old on pr and records why p was chosen. An
+// explicit choice comes with a zero Draw.
+func reassign(pr *PullRequest, old string, p Pick, d Draw) string {
+	for k, v := range pr.AssignedReviewers {
+		if v == old {
+			pr.AssignedReviewers[k] = p.UserID
+		}
+	}
+	if pr.Assignment == nil {
+		pr.Assignment = &Assignment{Strategy: d.Strategy}
+	}
+	pr.Assignment.replace(old, p.UserID, fmt.Sprintf("replaces %s; %s", old, p.Reason), d)
+	pr.dropReview(old)
+	return p.UserID
+}
+
+// staff picks the reviewers of pr out of pool. The count comes from
+// pr.RequiredReviewers, the team or the global setting; when the team is too
+// small pr is marked understaffed, or rejected if strict is set.
+func staff(a *Assigner, pr *PullRequest, pool *reviewPool, load map[string]int, strict bool) error {
+	pr.RequiredReviewers = a.required(pr.RequiredReviewers, pool.Required)
+	if free := len(pool.withLoad(load)); strict && free < pr.RequiredReviewers {
+		return errs.ErrNotEnoughReviewers.
+			WithMessage(fmt.Sprintf("team %s has %d active reviewers below capacity, %d required",
+				pool.Team, free, pr.RequiredReviewers)).
+			WithIDs(pr.PullRequestID, pool.Team)
+	}
+	picks, d := a.pick(pool, load, pr.RequiredReviewers)
+	pr.AssignedReviewers = pickIDs(picks)
+	pr.Assignment = newAssignment(d, picks)
+	pr.checkStaffed()
+	return nil
+}
+
+// preview runs staff on a copy of pr and reports the pool it picked from.
+// It uses the seed of the next real pick without consuming it, so as long as
+// nothing else changes the following CreatePR picks the same reviewers.
+func preview(a *Assigner, pr PullRequest, pool *reviewPool, load map[string]int, strict bool) (*AssignmentPreview, error) {
+	pool.dryRun = true
+	excluded := append([]Exclusion{}, pool.Excluded...)
+	for _, c := range pool.Candidates {
+		if c.Capacity > 0 && load[c.UserID] >= c.Capacity {
+			excluded = append(excluded, Exclusion{UserID: c.UserID, Reason: ExcludedCapacity})
+		}
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].UserID < excluded[j].UserID })
+	candidates := pool.withLoad(load)
+	if err := staff(a, &pr, pool, load, strict); err != nil {
+		return nil, err
+	}
+	return &AssignmentPreview{
+		PullRequestID:     pr.PullRequestID,
+		AuthorID:          pr.AuthorID,
+		TeamName:          pool.Team,
+		Strategy:          pr.Assignment.Strategy,
+		RequiredReviewers: pr.RequiredReviewers,
+		Candidates:        candidates,
+		Excluded:          excluded,
+		AssignedReviewers: pr.AssignedReviewers,
+		Understaffed:      pr.Understaffed,
+		Assignment:        pr.Assignment,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package dbtablesgo
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteDialect keeps assigned_reviewers as a JSON array in a TEXT column
+// and queries it through json_each.
+type sqliteDialect struct{}
+
+func NewSQLiteStore(db *sql.DB) *SQLStore {
+	return &SQLStore{Db: db, Assigner: defaultAssigner(), d: sqliteDialect{}}
+}
+
+func GetSQLitePath() string {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "pr_service.db"
+	}
+	return fmt.Sprintf(
+		"file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path,
+	)
+}
+
+type jsonArray struct {
+	v   []string
+	dst *[]string
+}
+
+func (a jsonArray) Value() (driver.Value, error) {
+	if a.v == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(a.v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (a jsonArray) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a.dst = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), a.dst)
+	case []byte:
+		return json.Unmarshal(v, a.dst)
+	}
+	return fmt.Errorf("cannot scan %T into string array", src)
+}
+
+func (sqliteDialect) array(v []string) interface{} {
+	return jsonArray{v: v}
+}
+
+func (sqliteDialect) scanArray(dst *[]string) interface{} {
+	return jsonArray{dst: dst}
+}
+
+func (sqliteDialect) inArray(elem, arr string) string {
+	return fmt.Sprintf("%s IN (SELECT value FROM json_each(%s))", elem, arr)
+}
+
+func (sqliteDialect) overlaps(a, b string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s) WHERE value IN (SELECT value FROM json_each(%s)))", a, b)
+}
+
+// forUpdate is empty because SQLite transactions are opened with
+// _txlock=immediate and already hold the write lock.
+func (sqliteDialect) forUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (sqliteDialect) name() string {
+	return "sqlite"
+}
@@ -0,0 +1,71 @@
+package dbtablesgo
+
+import "time"
+
+// StatsFilter narrows statistics down to pull requests created in
+// [From, To) whose author belongs to Team. Zero values mean no limit.
+type StatsFilter struct {
+	From *time.Time
+	To   *time.Time
+	Team string
+}
+
+type Stats struct {
+	AssignmentsByUser map[string]int `json:"assignments_by_user"`
+	AssignmentsByTeam map[string]int `json:"assignments_by_team"`
+	AssignmentsByPR   map[string]int `json:"assignments_by_pr"`
+	PullRequests      int            `json:"pull_requests"`
+	DraftPRs          int            `json:"draft_prs"`
+	OpenPRs           int            `json:"open_prs"`
+	MergedPRs         int            `json:"merged_prs"`
+	ClosedPRs         int            `json:"closed_prs"`
+	DeclinesByUser    map[string]int `json:"declines_by_user"`
+	Declines          []Decline      `json:"declines"`
+}
+
+func (f StatsFilter) match(pr PullRequest, authorTeam string) bool {
+	if f.From != nil && pr.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !pr.CreatedAt.Before(*f.To) {
+		return false
+	}
+	return f.Team == "" || f.Team == authorTeam
+}
+
+// buildStats aggregates prs, already filtered, using teamOf to find the team
+// of every reviewer. Only declines on prs are counted.
+func buildStats(prs []PullRequest, teamOf map[string]string, declines []Decline) *Stats {
+	stats := &Stats{
+		AssignmentsByUser: make(map[string]int),
+		AssignmentsByTeam: make(map[string]int),
+		AssignmentsByPR:   make(map[string]int),
+		DeclinesByUser:    make(map[string]int),
+		Declines:          []Decline{},
+	}
+	for _, pr := range prs {
+		stats.PullRequests++
+		switch pr.Status {
+		case StatusDraft:
+			stats.DraftPRs++
+		case StatusOpen:
+			stats.OpenPRs++
+		case StatusMerged:
+			stats.MergedPRs++
+		case StatusClosed:
+			stats.ClosedPRs++
+		}
+		stats.AssignmentsByPR[pr.PullRequestID] = len(pr.AssignedReviewers)
+		for _, id := range pr.AssignedReviewers {
+			stats.AssignmentsByUser[id]++
+			stats.AssignmentsByTeam[teamOf[id]]++
+		}
+	}
+	for _, d := range declines {
+		if _, ok := stats.AssignmentsByPR[d.PullRequestID]; ok {
+			stats.DeclinesByUser[d.UserID]++
+			stats.Declines = append(stats.Declines, d)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,223 @@
+package dbtablesgo
+
+import "time"
+
+type TeamMember struct {
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	IsActive       bool   `json:"is_active"`
+	ReviewWeight   int    `json:"review_weight,omitempty"`
+	MaxOpenReviews int    `json:"max_open_reviews,omitempty"`
+	OpenReviews    int    `json:"open_reviews"`
+	IsLead         bool   `json:"is_lead,omitempty"`
+}
+
+type Team struct {
+	TeamName          string       `json:"team_name"`
+	ReviewerStrategy  string       `json:"reviewer_strategy,omitempty"`
+	RequiredReviewers int          `json:"required_reviewers,omitempty"`
+	Members           []TeamMember `json:"members"`
+}
+
+type User struct {
+	UserID       string `json:"user_id"`
+	Username     string `json:"username"`
+	TeamName     string `json:"team_name"`
+	IsActive     bool   `json:"is_active"`
+	ReviewWeight int    `json:"review_weight,omitempty"`
+	// MaxOpenReviews caps the OPEN pull requests the user reviews at once,
+	// 0 means no limit.
+	MaxOpenReviews int `json:"max_open_reviews,omitempty"`
+	OpenReviews    int `json:"open_reviews"`
+}
+
+// Reviewer identifies a user taking part in a reassignment.
+type Reviewer struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	TeamName string `json:"team_name"`
+}
+
+// Reassignment is the result of ChangeReviewer.
+type Reassignment struct {
+	PullRequest *PullRequest `json:"pr"`
+	OldReviewer Reviewer     `json:"old_reviewer"`
+	NewReviewer Reviewer     `json:"new_reviewer"`
+	// Decline is set when the old reviewer declined the review.
+	Decline *Decline `json:"decline,omitempty"`
+}
+
+type PullRequest struct {
+	PullRequestID     string      `json:"pull_request_id"`
+	PullRequestName   string      `json:"pull_request_name"`
+	AuthorID          string      `json:"author_id"`
+	Status            string      `json:"status"`
+	AssignedReviewers []string    `json:"assigned_reviewers"`
+	CreatedAt         time.Time   `json:"createdAt,omitempty"`
+	MergedAt          *time.Time  `json:"mergedAt,omitempty"`
+	ClosedAt          *time.Time  `json:"closedAt,omitempty"`
+	RequiredReviewers int         `json:"required_reviewers"`
+	Understaffed      bool        `json:"understaffed"`
+	Assignment        *Assignment `json:"assignment,omitempty"`
+	Reviews           []Review    `json:"reviews"`
+}
+
+// checkStaffed sets Understaffed when the pull request has fewer reviewers
+// than it asked for.
+func (pr *PullRequest) checkStaffed() {
+	pr.Understaffed = len(pr.AssignedReviewers) < pr.RequiredReviewers
+}
+
+// Assignment records how the reviewers of a pull request were chosen: the
+// strategy and random seed used on creation and, per reviewer, why they were
+// picked and the draw that picked them. Reviewers chosen explicitly have no
+// draw.
+type Assignment struct {
+	Strategy string            `json:"strategy"`
+	Seed     Seed              `json:"seed"`
+	Reasons  map[string]string `json:"reasons,omitempty"`
+	Draws    map[string]Draw   `json:"draws,omitempty"`
+}
+
+// replace puts reviewer, picked by d for reason, in place of old.
+func (a *Assignment) replace(old, reviewer, reason string, d Draw) {
+	if a.Reasons == nil {
+		a.Reasons = make(map[string]string)
+	}
+	if a.Draws == nil {
+		a.Draws = make(map[string]Draw)
+	}
+	delete(a.Reasons, old)
+	delete(a.Draws, old)
+	if reviewer == "" {
+		return
+	}
+	a.Reasons[reviewer] = reason
+	if d.Strategy != "" {
+		a.Draws[reviewer] = d
+	}
+}
+
+// Reasons a teammate is left out of the review pool.
+const (
+	ExcludedAuthor   = "author"
+	ExcludedInactive = "inactive"
+	ExcludedAssigned = "already_assigned"
+	ExcludedCapacity = "at_capacity"
+	// ExcludedNotMember is only reported for an explicitly requested
+	// reviewer from outside the author's team.
+	ExcludedNotMember = "not_member"
+)
+
+type Exclusion struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+// AssignmentPreview shows what CreatePR would do with a pull request without
+// writing anything. Random strategies may pick differently on the real call.
+type AssignmentPreview struct {
+	PullRequestID     string      `json:"pull_request_id"`
+	AuthorID          string      `json:"author_id"`
+	TeamName          string      `json:"team_name"`
+	Strategy          string      `json:"strategy"`
+	RequiredReviewers int         `json:"required_reviewers"`
+	Candidates        []Candidate `json:"candidates"`
+	Excluded          []Exclusion `json:"excluded"`
+	AssignedReviewers []string    `json:"assigned_reviewers"`
+	Understaffed      bool        `json:"understaffed"`
+	Assignment        *Assignment `json:"assignment"`
+}
+
+type DeactivationMode string
+
+const (
+	// DeactivateStrict rolls the whole batch back when some PR can't be
+	// re-staffed.
+	DeactivateStrict DeactivationMode = "strict"
+	// DeactivateLenient still deactivates everyone; reviewers without a
+	// replacement are dropped and the PR is flagged as understaffed.
+	DeactivateLenient DeactivationMode = "lenient"
+)
+
+type Replacement struct {
+	RemovedReviewerID string `json:"removed_reviewer_id"`
+	ReplacedBy        string `json:"replaced_by,omitempty"`
+	LeftEmpty         bool   `json:"left_empty"`
+}
+
+type AffectedPR struct {
+	PullRequestID     string        `json:"pull_request_id"`
+	AuthorID          string        `json:"author_id"`
+	AssignedReviewers []string      `json:"assigned_reviewers"`
+	Replacements      []Replacement `json:"replacements"`
+	Understaffed      bool          `json:"understaffed"`
+}
+
+type DeactivationReport struct {
+	Mode            DeactivationMode `json:"mode"`
+	Deactivated     []string         `json:"deactivated"`
+	AlreadyInactive []string         `json:"already_inactive"`
+	Unknown         []string         `json:"unknown"`
+	PullRequests    []AffectedPR     `json:"pull_requests"`
+}
+
+func newDeactivationReport(mode DeactivationMode) *DeactivationReport {
+	return &DeactivationReport{
+		Mode:            mode,
+		Deactivated:     []string{},
+		AlreadyInactive: []string{},
+		Unknown:         []string{},
+		PullRequests:    []AffectedPR{},
+	}
+}
+
+type Store interface {
+	TeamAdd(team Team) (*Team, error)
+	GetTeam(teamname string) (Team, error)
+	ListTeams(limit, offset int) (*TeamPage, error)
+	// AddMembers creates or updates users as members of an existing team,
+	// moving them out of their previous team.
+	AddMembers(teamName string, members []TeamMember) (Team, error)
+	// RemoveMembers leaves the users without a team; the reviews they hold
+	// stay assigned.
+	RemoveMembers(teamName string, userIDs []string) (Team, error)
+	RenameTeam(oldName, newName string) (Team, error)
+	DeleteTeam(teamName string, policy TeamDeletePolicy) (*TeamDeletionReport, error)
+	GetUser(userID string) (*User, error)
+	SetIsActive(userID string, isActive bool) (*User, error)
+	SetCapacity(userID string, maxOpenReviews int) (*User, error)
+	DeactivateManyUsers(ids []string, mode DeactivationMode) (*DeactivationReport, error)
+	// HandOverReviews moves every OPEN review of userID to a teammate,
+	// preferring successorID when it is set, in one transaction. The user
+	// stays active.
+	HandOverReviews(userID, successorID string) (*HandoverReport, error)
+	// CreatePR assigns pr.RequiredReviewers reviewers, falling back to the
+	// team and then the global count when it is 0. With strict set a team
+	// that is too small fails with NOT_ENOUGH_REVIEWERS instead of giving an
+	// understaffed pull request. A pr with status DRAFT is created without
+	// reviewers.
+	CreatePR(pr *PullRequest, strict bool) (*PullRequest, error)
+	PreviewPR(pr PullRequest, strict bool) (*AssignmentPreview, error)
+	GetPR(prID string) (*PullRequest, error)
+	// StatusMerged merges an OPEN pull request that meets the merge policy.
+	// A non-nil override with Actor and Reason merges regardless and is
+	// written to the audit log.
+	StatusMerged(prID string, override *MergeOverride) (*PullRequest, error)
+	MergeOverrides(prID string) ([]MergeOverride, error)
+	// Transition moves a pull request through its lifecycle, assigning
+	// reviewers when one without any becomes OPEN.
+	Transition(prID string, action PRAction) (*PullRequest, error)
+	// ChangeReviewer replaces oldReviewerID on an OPEN pull request with
+	// newReviewerID, or with a teammate picked by the strategy when it is
+	// empty.
+	ChangeReviewer(prID, oldReviewerID, newReviewerID string) (*Reassignment, error)
+	// DeclineReview takes reviewerID off an OPEN pull request at their own
+	// request, replaces them using the strategy and records the reason.
+	DeclineReview(prID, reviewerID, reason string) (*Reassignment, error)
+	GetReview(userID string) ([]PullRequest, error)
+	// SubmitReview records an APPROVED or CHANGES_REQUESTED verdict of an
+	// assigned reviewer.
+	SubmitReview(prID, reviewerID, verdict string) (*PullRequest, error)
+	GetStats(filter StatsFilter) (*Stats, error)
+}
@@ -0,0 +1,91 @@
+package dbtablesgo
+
+import (
+	"fmt"
+	"strings"
+
+	"avito_otbor/errs"
+)
+
+// TeamSummary is a team in the /team/list page.
+type TeamSummary struct {
+	TeamName          string `json:"team_name"`
+	ReviewerStrategy  string `json:"reviewer_strategy,omitempty"`
+	RequiredReviewers int    `json:"required_reviewers,omitempty"`
+	MemberCount       int    `json:"member_count"`
+}
+
+// TeamPage is one page of teams ordered by name.
+type TeamPage struct {
+	Teams  []TeamSummary `json:"teams"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+// TeamDeletePolicy decides what DeleteTeam does with pull requests of the
+// team's members that are still in progress.
+type TeamDeletePolicy string
+
+const (
+	// TeamDeleteReject refuses to delete a team while its members author a
+	// DRAFT or OPEN pull request or review an OPEN one.
+	TeamDeleteReject TeamDeletePolicy = "reject"
+	// TeamDeleteClose closes the DRAFT and OPEN pull requests authored by
+	// members and takes members off other OPEN pull requests, replacing them
+	// from the author's team when possible.
+	TeamDeleteClose TeamDeletePolicy = "close"
+)
+
+// TeamDeletionReport tells what DeleteTeam did. The members are kept as
+// users without a team.
+type TeamDeletionReport struct {
+	TeamName     string           `json:"team_name"`
+	Policy       TeamDeletePolicy `json:"policy"`
+	Members      []string         `json:"members"`
+	ClosedPRs    []string         `json:"closed_prs"`
+	PullRequests []AffectedPR     `json:"pull_requests"`
+}
+
+func newTeamDeletionReport(teamName string, policy TeamDeletePolicy) *TeamDeletionReport {
+	return &TeamDeletionReport{
+		TeamName:     teamName,
+		Policy:       policy,
+		Members:      []string{},
+		ClosedPRs:    []string{},
+		PullRequests: []AffectedPR{},
+	}
+}
+
+// inProgress reports whether pr keeps a team with the given members from
+// being deleted under TeamDeleteReject.
+func inProgress(pr PullRequest, members []string) bool {
+	switch pr.Status {
+	case StatusDraft:
+		return contains(members, pr.AuthorID)
+	case StatusOpen:
+		return contains(members, pr.AuthorID) || hasAny(pr.AssignedReviewers, members)
+	}
+	return false
+}
+
+// notIn returns the ids missing from set.
+func notIn(ids, set []string) []string {
+	var res []string
+	for _, id := range ids {
+		if !contains(set, id) && !contains(res, id) {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+func teamInUse(teamName string, prs []PullRequest) error {
+	ids := make([]string, 0, len(prs))
+	for _, pr := range prs {
+		ids = append(ids, pr.PullRequestID)
+	}
+	return errs.ErrTeamInUse.
+		WithMessage(fmt.Sprintf("members of team %s have pull requests in progress: %s", teamName, strings.Join(ids, ", "))).
+		WithIDs(append([]string{teamName}, ids...)...)
+}
@@ -0,0 +1,78 @@
+package errs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is a domain error. Code is the machine readable error code returned
+// to clients, IDs are the affected teams, users or pull requests and Details
+// are extra machine readable codes, such as the unmet parts of a policy.
+type Error struct {
+	Code    string
+	Message string
+	IDs     []string
+	Details []string
+}
+
+var (
+	ErrNotFound           = &Error{Code: "NOT_FOUND", Message: "resource not found"}
+	ErrTeamExists         = &Error{Code: "TEAM_EXISTS", Message: "team already exists"}
+	ErrTeamInUse          = &Error{Code: "TEAM_HAS_OPEN_PRS", Message: "team members have pull requests in progress"}
+	ErrPRExists           = &Error{Code: "PR_EXISTS", Message: "pull request already exists"}
+	ErrAuthorNotFound     = &Error{Code: "AUTHOR_NOT_FOUND", Message: "author not found"}
+	ErrAlreadyMerged      = &Error{Code: "ALREADY_MERGED", Message: "pull request is already merged"}
+	ErrPRMerged           = &Error{Code: "PR_MERGED", Message: "pull request is merged and can't be changed"}
+	ErrNotAssigned        = &Error{Code: "NOT_ASSIGNED", Message: "reviewer is not assigned to pull request"}
+	ErrNoReplacement      = &Error{Code: "NO_REPLACEMENT_FOUND", Message: "no active teammate to replace reviewer"}
+	ErrNotEligible        = &Error{Code: "REVIEWER_NOT_ELIGIBLE", Message: "user can't review this pull request"}
+	ErrNoReviewers        = &Error{Code: "NO_REVIEWERS", Message: "no reviewers available"}
+	ErrNotEnoughReviewers = &Error{Code: "NOT_ENOUGH_REVIEWERS", Message: "team doesn't have enough active reviewers"}
+	ErrInvalidTransition  = &Error{Code: "INVALID_TRANSITION", Message: "pull request can't make this transition"}
+	ErrNotOpen            = &Error{Code: "PR_NOT_OPEN", Message: "pull request is not open"}
+	ErrMergeBlocked       = &Error{Code: "MERGE_BLOCKED", Message: "merge policy not met"}
+)
+
+func (e *Error) Error() string {
+	if len(e.IDs) == 0 {
+		return e.Code + ": " + e.Message
+	}
+	return e.Code + ": " + e.Message + " (" + strings.Join(e.IDs, ", ") + ")"
+}
+
+// Is makes errors.Is match any error with the same code, so a sentinel
+// matches the copies made by WithIDs and WithMessage.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithIDs returns a copy of e carrying the affected ids.
+func (e *Error) WithIDs(ids ...string) *Error {
+	c := *e
+	c.IDs = append([]string{}, ids...)
+	return &c
+}
+
+// WithDetails returns a copy of e carrying details.
+func (e *Error) WithDetails(details ...string) *Error {
+	c := *e
+	c.Details = append([]string{}, details...)
+	return &c
+}
+
+// WithMessage returns a copy of e with a more specific message.
+func (e *Error) WithMessage(msg string) *Error {
+	c := *e
+	c.Message = msg
+	return &c
+}
+
+// As returns the domain error wrapped in err, if any.
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
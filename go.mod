@@ -2,13 +2,15 @@ module avito_otbor
 
 go 1.24.3
 
-require modernc.org/sqlite v1.40.1
+require (
+	github.com/go-chi/chi/v5 v5.2.3
+	github.com/lib/pq v1.10.9
+	modernc.org/sqlite v1.40.1
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
-	github.com/go-chi/chi/v5 v5.2.3 // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
@@ -6,20 +6,74 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		return
+	}
 
 	r := chi.NewRouter()
-	if err := dbtablesgo.DbInit(); err != nil {
+	store, err := dbtablesgo.DbInit()
+	if err != nil {
 		log.Fatal("Database initialization failed:", err)
 	}
-	api.Init(r)
+	api.Init(r, store)
 	fmt.Println("Server is running on port :8080")
 	if err := http.ListenAndServe(":8080", r); err != nil {
 		log.Fatal("Server failed:", err)
 	}
 
 }
+
+// migrate handles `pr-service migrate up|down [steps]|version`.
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | version")
+	}
+	store, err := dbtablesgo.OpenSQLStore()
+	if err != nil {
+		return err
+	}
+	m, err := store.Migrator()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Println("applied migrations:", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("bad steps %q", args[1])
+			}
+		}
+		reverted, err := m.Down(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Println("reverted migrations:", reverted)
+	case "version":
+		version, err := m.Version()
+		if err != nil {
+			return err
+		}
+		fmt.Println("schema version:", version)
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	return nil
+}